@@ -29,6 +29,9 @@ func addAccount(context *cli.Context, wallet Wallet, content string) error {
 		content = string(rawData)
 	}
 
+	// Transactions before the birthday height will not be scanned
+	birthday := uint32(context.Uint("from-height"))
+
 	var err error
 	var programHash *Uint168
 	if !strings.Contains(content, ",") { // single public key
@@ -37,7 +40,7 @@ func addAccount(context *cli.Context, wallet Wallet, content string) error {
 			return err
 		}
 
-		programHash, err = wallet.AddStandardAccount(publicKey)
+		programHash, err = wallet.AddStandardAccount(publicKey, birthday)
 		if err != nil {
 			return err
 		}
@@ -60,14 +63,22 @@ func addAccount(context *cli.Context, wallet Wallet, content string) error {
 			return errors.New("M must be greater than half number of public keys, less than number of public keys")
 		}
 
-		programHash, err = wallet.AddMultiSignAccount(uint(M), publicKeys...)
+		programHash, err = wallet.AddMultiSignAccount(uint(M), birthday, publicKeys...)
 		if err != nil {
 			return err
 		}
 	}
 
-	// When add a new address, reset stored height to trigger synchronize blocks.
-	wallet.CurrentHeight(ResetHeightCode)
+	// Scan synchronized blocks for the new address from it's birthday,
+	// the next synchronization resumes the scan if it's interrupted
+	addr, err := wallet.GetAddressInfo(programHash)
+	if err != nil {
+		return err
+	}
+	err = wallet.Rescan(getInterruptContext(), []*Address{addr})
+	if err != nil {
+		return err
+	}
 
 	addrs, err := wallet.GetAddresses()
 	if err != nil || len(addrs) == 0 {
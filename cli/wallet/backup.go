@@ -0,0 +1,47 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+	walt "github.com/elastos/Elastos.ELA.Client/wallet"
+)
+
+func backupWallet(name string, password []byte, wallet walt.Wallet, fileName string, encrypt bool) error {
+	var err error
+	var archivePassword []byte
+	// The encrypted wallet data is not written to a plaintext archive
+	if encrypt || walt.EncryptedDataStoreExists() {
+		password, err = GetPassword(password, false)
+		if err != nil {
+			return err
+		}
+		// The archive is encrypted with the wallet password
+		err = wallet.Open(name, password)
+		if err != nil {
+			return err
+		}
+		archivePassword = password
+	}
+	backup, err := wallet.CreateBackup(name, fileName, archivePassword)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wallet backed up to", fileName, "keystores:", len(backup.Keystores),
+		"addresses:", len(backup.Addresses()), "encrypted:", backup.Encrypted)
+	return nil
+}
+
+func restoreWallet(fileName string, password []byte) error {
+	password, err := GetPassword(password, false)
+	if err != nil {
+		return err
+	}
+	backup, err := walt.RestoreBackup(fileName, password)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wallet restored to", config.WalletDir(), "keystores:", len(backup.Keystores),
+		"addresses:", len(backup.Addresses()), "height:", backup.Height())
+	return nil
+}
@@ -0,0 +1,144 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastos/Elastos.ELA.Client/rpc"
+	walt "github.com/elastos/Elastos.ELA.Client/wallet"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+)
+
+func showBalanceAt(wallet walt.Wallet, value string) error {
+	if err := checkHistorySynced(); err != nil {
+		return err
+	}
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
+	// The wallet height is the next block to sync
+	syncedHeight := wallet.CurrentHeight(walt.QueryHeightCode) - 1
+
+	var height uint32
+	var hasHeight = true
+	if h, err := strconv.ParseUint(value, 10, 32); err == nil {
+		height = uint32(h)
+		if height > syncedHeight {
+			return errors.New(fmt.Sprint("height ", height, " is above the synced height ", syncedHeight))
+		}
+	} else {
+		t, err := time.Parse(DateFormat, value)
+		if err != nil {
+			return errors.New("invalid height or date " + value + ", use a block height or format " + DateFormat)
+		}
+		height, hasHeight, err = heightAtTime(uint32(t.Add(24*time.Hour).Unix())-1, syncedHeight)
+		if err != nil {
+			return err
+		}
+	}
+	if hasHeight {
+		if err := walt.CheckSpentUTXOs(wallet, height); err != nil {
+			fmt.Println("warning:", err)
+			return nil
+		}
+	}
+
+	addresses, err := wallet.GetAddresses()
+	if err != nil {
+		return errors.New("get wallet addresses failed")
+	}
+
+	if hasHeight {
+		fmt.Println("Balance at height", height)
+	} else {
+		fmt.Println("Balance before the first block")
+	}
+	// print header
+	fmt.Printf("%5s %34s %-20s%22s\n", "INDEX", "ADDRESS", "BALANCE", "(LOCKED)")
+	fmt.Println("-----", strings.Repeat("-", 34), strings.Repeat("-", 42))
+
+	for i, addr := range addresses {
+		available := Fixed64(0)
+		locked := Fixed64(0)
+		if hasHeight {
+			UTXOs, err := wallet.GetAddressUTXOsAt(addr.ProgramHash, height)
+			if err != nil {
+				return errors.New("get " + addr.Address + " UTXOs failed")
+			}
+			for _, utxo := range UTXOs {
+				if utxo.LockTime <= height {
+					available += *utxo.Amount
+				} else {
+					locked += *utxo.Amount
+				}
+			}
+		}
+		fmt.Printf("%5d %34s %-20s%22s\n", i+1, addr.Address, available.String(), "("+locked.String()+")")
+		fmt.Println("-----", strings.Repeat("-", 34), strings.Repeat("-", 42))
+	}
+
+	return nil
+}
+
+func checkWallet(wallet walt.Wallet, repair bool) error {
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
+	report, err := wallet.Check(getInterruptContext(), repair)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Checked at height", report.Height)
+	// print header
+	fmt.Printf("%5s %34s %20s %20s\n", "INDEX", "ADDRESS", "STORED", "NODE")
+	fmt.Println("-----", strings.Repeat("-", 34), strings.Repeat("-", 20), strings.Repeat("-", 20))
+	for i, balance := range report.Balances {
+		fmt.Printf("%5d %34s %20s %20s\n", i+1, balance.Address, balance.Stored.String(), balance.Node.String())
+	}
+	fmt.Println("-----", strings.Repeat("-", 34), strings.Repeat("-", 20), strings.Repeat("-", 20))
+
+	for _, issue := range report.Issues {
+		fmt.Printf("[%s] %s\n", issue.Kind, issue.Detail)
+	}
+	switch {
+	case len(report.Issues) == 0:
+		fmt.Println("No discrepancy found")
+	case report.Repaired:
+		fmt.Println(len(report.Issues), "discrepancies found and repaired")
+	default:
+		fmt.Println(len(report.Issues), "discrepancies found, use --repair to fix them")
+	}
+	return nil
+}
+
+// heightAtTime finds the height of the last block until time t by a binary search over the block times
+// up to syncedHeight. It returns false if the first block is after t.
+func heightAtTime(t uint32, syncedHeight uint32) (uint32, bool, error) {
+	// The first block after t is in [low, high], or there is none if it's syncedHeight + 1
+	low, high := uint32(0), syncedHeight+1
+	for low < high {
+		middle := low + (high-low)/2
+		hash, err := rpc.GetBlockHash(middle)
+		if err != nil {
+			return 0, false, err
+		}
+		block, err := rpc.GetBlock(hash)
+		if err != nil {
+			return 0, false, err
+		}
+		if block.Time > t {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+	if low == 0 {
+		return 0, false, nil
+	}
+	return low - 1, true, nil
+}
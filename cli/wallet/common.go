@@ -3,10 +3,14 @@ package wallet
 import (
 	"os"
 	"fmt"
+	"sync"
 	"bufio"
 	"errors"
+	"context"
 	"strings"
 	"strconv"
+	"syscall"
+	"os/signal"
 
 	walt "github.com/elastos/Elastos.ELA.Client/wallet"
 
@@ -45,6 +49,36 @@ func GetPassword(password []byte, confirmed bool) ([]byte, error) {
 	return password, nil
 }
 
+var interruptContext context.Context
+var interruptOnce sync.Once
+
+// getInterruptContext returns the context canceled when the process is interrupted,
+// a second interrupt terminates the process immediately.
+func getInterruptContext() context.Context {
+	interruptOnce.Do(func() {
+		var cancel context.CancelFunc
+		interruptContext, cancel = context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			signal.Stop(signals)
+			cancel()
+		}()
+	})
+	return interruptContext
+}
+
+// syncChainData synchronizes the wallet until the process is interrupted, which stops it
+// after the block being applied, so the next command resumes from there.
+func syncChainData(wallet walt.Wallet) error {
+	err := wallet.SyncChainData(getInterruptContext())
+	if err != nil {
+		return errors.New("synchronize wallet failed: " + err.Error())
+	}
+	return nil
+}
+
 func ShowAccountInfo(name string, password []byte) error {
 	var err error
 	password, err = GetPassword(password, false)
@@ -0,0 +1,251 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	walt "github.com/elastos/Elastos.ELA.Client/wallet"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	"github.com/urfave/cli"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatOFX  = "ofx"
+
+	OFXTimeFormat = "20060102150405"
+)
+
+// exportRecord is a transaction history record with the running balance
+type exportRecord struct {
+	Time           string   `json:"time"`
+	Height         uint32   `json:"height"`
+	TxID           string   `json:"txid"`
+	Address        string   `json:"address"`
+	Type           string   `json:"type"`
+	Amount         string   `json:"amount"`
+	Fee            string   `json:"fee"`
+	Balance        string   `json:"balance"`
+	Counterparties []string `json:"counterparties"`
+	Memo           string   `json:"memo"`
+
+	history *walt.TxHistory
+	balance Fixed64
+}
+
+func exportHistory(context *cli.Context, wallet walt.Wallet, fileName string) error {
+	if err := checkHistorySynced(); err != nil {
+		return err
+	}
+	format := strings.ToLower(context.String("format"))
+	if format == "" {
+		format = formatOfFile(fileName)
+	}
+	if format != FormatCSV && format != FormatJSON && format != FormatOFX {
+		return errors.New("unsupported export format " + format + ", use [csv, json, ofx]")
+	}
+
+	filter, err := getHistoryFilter(context)
+	if err != nil {
+		return err
+	}
+
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
+
+	// Running balance counts all records before the selected range
+	histories, err := wallet.GetTxHistory(&walt.HistoryFilter{ProgramHash: filter.ProgramHash})
+	if err != nil {
+		return err
+	}
+	var records []*exportRecord
+	var balance Fixed64
+	for _, history := range histories {
+		balance += history.Amount
+		if !inRange(filter, history) {
+			continue
+		}
+		records = append(records, newExportRecord(history, balance))
+	}
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	switch format {
+	case FormatCSV:
+		err = writeCSV(writer, records)
+	case FormatJSON:
+		err = writeJSONLines(writer, records)
+	case FormatOFX:
+		account := context.String("address")
+		if account == "" {
+			account = "wallet"
+		}
+		err = writeOFX(writer, account, balance, records)
+	}
+	if err != nil {
+		return err
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Exported", len(records), "records to", fileName)
+	return nil
+}
+
+func formatOfFile(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json", ".jsonl":
+		return FormatJSON
+	case ".ofx":
+		return FormatOFX
+	default:
+		return FormatCSV
+	}
+}
+
+func inRange(filter *walt.HistoryFilter, history *walt.TxHistory) bool {
+	if filter.FromHeight > 0 && history.Height < filter.FromHeight {
+		return false
+	}
+	if filter.ToHeight > 0 && history.Height > filter.ToHeight {
+		return false
+	}
+	if filter.FromTime > 0 && history.Time < filter.FromTime {
+		return false
+	}
+	if filter.ToTime > 0 && history.Time > filter.ToTime {
+		return false
+	}
+	return true
+}
+
+func newExportRecord(history *walt.TxHistory, balance Fixed64) *exportRecord {
+	txType := "incoming"
+	if history.Amount < 0 {
+		txType = "outgoing"
+	}
+	return &exportRecord{
+		Time:           time.Unix(int64(history.Time), 0).UTC().Format(time.RFC3339),
+		Height:         history.Height,
+		TxID:           history.TxID,
+		Address:        history.Address,
+		Type:           txType,
+		Amount:         history.Amount.String(),
+		Fee:            history.Fee.String(),
+		Balance:        balance.String(),
+		Counterparties: history.Counterparties,
+		Memo:           history.Memo,
+		history:        history,
+		balance:        balance,
+	}
+}
+
+func writeCSV(w io.Writer, records []*exportRecord) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"time", "height", "txid", "address", "type", "amount", "fee", "balance",
+		"counterparties", "memo"})
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		err = writer.Write([]string{r.Time, strconv.FormatUint(uint64(r.Height), 10), r.TxID, r.Address, r.Type,
+			r.Amount, r.Fee, r.Balance, strings.Join(r.Counterparties, " "), r.Memo})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSONLines(w io.Writer, records []*exportRecord) error {
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		err := encoder.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeOFX(w io.Writer, account string, balance Fixed64, records []*exportRecord) error {
+	now := time.Now().UTC().Format(OFXTimeFormat)
+	start, end := now, now
+	if len(records) > 0 {
+		start = ofxTime(records[0].history.Time)
+		end = ofxTime(records[len(records)-1].history.Time)
+		balance = records[len(records)-1].balance
+	}
+
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`)
+	fmt.Fprintln(w, "<OFX>")
+	fmt.Fprintln(w, "<SIGNONMSGSRSV1><SONRS>")
+	fmt.Fprintln(w, "<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>")
+	fmt.Fprintf(w, "<DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE>\n", now)
+	fmt.Fprintln(w, "</SONRS></SIGNONMSGSRSV1>")
+	fmt.Fprintln(w, "<BANKMSGSRSV1><STMTTRNRS>")
+	fmt.Fprintln(w, "<TRNUID>0</TRNUID>")
+	fmt.Fprintln(w, "<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>")
+	fmt.Fprintln(w, "<STMTRS>")
+	fmt.Fprintln(w, "<CURDEF>XXX</CURDEF>")
+	fmt.Fprintf(w, "<BANKACCTFROM><BANKID>ELA</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>\n",
+		escapeXML(account))
+	fmt.Fprintf(w, "<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>\n", start, end)
+	for _, r := range records {
+		trnType := "CREDIT"
+		if r.history.Amount < 0 {
+			trnType = "DEBIT"
+		}
+		fmt.Fprintln(w, "<STMTTRN>")
+		fmt.Fprintf(w, "<TRNTYPE>%s</TRNTYPE>\n", trnType)
+		fmt.Fprintf(w, "<DTPOSTED>%s</DTPOSTED>\n", ofxTime(r.history.Time))
+		fmt.Fprintf(w, "<TRNAMT>%s</TRNAMT>\n", r.Amount)
+		fmt.Fprintf(w, "<FITID>%s-%s</FITID>\n", r.TxID, r.Address)
+		if len(r.Counterparties) > 0 {
+			fmt.Fprintf(w, "<NAME>%s</NAME>\n", escapeXML(r.Counterparties[0]))
+		}
+		if r.Memo != "" {
+			fmt.Fprintf(w, "<MEMO>%s</MEMO>\n", escapeXML(r.Memo))
+		}
+		fmt.Fprintln(w, "</STMTTRN>")
+	}
+	fmt.Fprintln(w, "</BANKTRANLIST>")
+	fmt.Fprintf(w, "<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n", balance.String(), end)
+	fmt.Fprintln(w, "</STMTRS>")
+	fmt.Fprintln(w, "</STMTTRNRS></BANKMSGSRSV1>")
+	fmt.Fprintln(w, "</OFX>")
+	return nil
+}
+
+func ofxTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format(OFXTimeFormat)
+}
+
+func escapeXML(s string) string {
+	buf := new(bytes.Buffer)
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
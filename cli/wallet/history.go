@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+	walt "github.com/elastos/Elastos.ELA.Client/wallet"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	"github.com/urfave/cli"
+)
+
+const (
+	DateFormat      = "2006-01-02"
+	DefaultPageSize = 20
+)
+
+func showHistory(context *cli.Context, wallet walt.Wallet) error {
+	if err := checkHistorySynced(); err != nil {
+		return err
+	}
+	filter, err := getHistoryFilter(context)
+	if err != nil {
+		return err
+	}
+
+	page := context.Int("page")
+	if page < 1 {
+		return errors.New("page number should be a positive integer")
+	}
+	pageSize := context.Int("pagesize")
+	if pageSize < 1 {
+		return errors.New("page size should be a positive integer")
+	}
+	filter.Offset = (page - 1) * pageSize
+	filter.Limit = pageSize
+
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
+	histories, err := wallet.GetTxHistory(filter)
+	if err != nil {
+		return err
+	}
+
+	// print header
+	fmt.Printf("%-8s %-10s %-64s %-34s %20s %14s\n", "HEIGHT", "DATE", "TXID", "ADDRESS", "AMOUNT", "FEE")
+	fmt.Println("--------", strings.Repeat("-", 10), strings.Repeat("-", 64), strings.Repeat("-", 34),
+		strings.Repeat("-", 20), strings.Repeat("-", 14))
+
+	for _, history := range histories {
+		date := time.Unix(int64(history.Time), 0).UTC().Format(DateFormat)
+		fmt.Printf("%-8d %-10s %-64s %-34s %20s %14s\n", history.Height, date, history.TxID,
+			history.Address, history.Amount.String(), history.Fee.String())
+		if len(history.Counterparties) > 0 {
+			fmt.Printf("%-8s %-10s %s\n", "", "", strings.Join(history.Counterparties, ", "))
+		}
+	}
+	fmt.Println("--------", strings.Repeat("-", 10), strings.Repeat("-", 64), strings.Repeat("-", 34),
+		strings.Repeat("-", 20), strings.Repeat("-", 14))
+	fmt.Println("Page:", page, "Records:", len(histories))
+
+	return nil
+}
+
+// checkHistorySynced refuses to read the transaction history and the spent UTXOs when they are not synchronized,
+// the unspent sync mode records neither of them, and the UTXOs it finds spent are spent at the synced height.
+func checkHistorySynced() error {
+	if config.Params().SyncMode == config.SyncModeUnspent {
+		return errors.New("transaction history is not recorded in sync mode " + config.SyncModeUnspent +
+			", set SyncMode to " + config.SyncModeBlocks + " and use --reset to resync")
+	}
+	return nil
+}
+
+func getHistoryFilter(context *cli.Context) (*walt.HistoryFilter, error) {
+	filter := &walt.HistoryFilter{
+		FromHeight: uint32(context.Uint("from-height")),
+		ToHeight:   uint32(context.Uint("to-height")),
+	}
+
+	if address := context.String("address"); address != "" {
+		programHash, err := Uint168FromAddress(address)
+		if err != nil {
+			return nil, errors.New("invalid address " + address)
+		}
+		filter.ProgramHash = programHash
+	}
+
+	if date := context.String("from-date"); date != "" {
+		t, err := time.Parse(DateFormat, date)
+		if err != nil {
+			return nil, errors.New("invalid date " + date + ", use format " + DateFormat)
+		}
+		filter.FromTime = uint32(t.Unix())
+	}
+
+	if date := context.String("to-date"); date != "" {
+		t, err := time.Parse(DateFormat, date)
+		if err != nil {
+			return nil, errors.New("invalid date " + date + ", use format " + DateFormat)
+		}
+		// Include the whole day
+		filter.ToTime = uint32(t.Add(24*time.Hour).Unix()) - 1
+	}
+
+	return filter, nil
+}
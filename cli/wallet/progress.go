@@ -0,0 +1,59 @@
+package wallet
+
+import (
+	"encoding/json"
+	"os"
+
+	walt "github.com/elastos/Elastos.ELA.Client/wallet"
+
+	"github.com/cheggaaa/pb"
+)
+
+// newProgressHandler reports the synchronization progress on stderr, with a progress bar when it's a terminal,
+// or in JSON lines otherwise, so the output of commands is not mixed with it. Nothing is reported if quiet.
+func newProgressHandler(quiet bool) walt.ProgressHandler {
+	if quiet {
+		return nil
+	}
+	if isTerminal(os.Stderr) {
+		return barProgressHandler()
+	}
+	encoder := json.NewEncoder(os.Stderr)
+	return func(event *walt.ProgressEvent) {
+		encoder.Encode(event)
+	}
+}
+
+func barProgressHandler() walt.ProgressHandler {
+	var bar *pb.ProgressBar
+	return func(event *walt.ProgressEvent) {
+		switch event.Event {
+		case walt.ProgressStart:
+			// The previous stage failed without finishing
+			if bar != nil {
+				bar.Finish()
+			}
+			bar = pb.New(event.Total)
+			bar.Output = os.Stderr
+			bar.Prefix(event.Stage + " ")
+			bar.Start()
+		case walt.ProgressBlock:
+			if bar != nil {
+				bar.Set(event.Current)
+			}
+		case walt.ProgressFinish:
+			if bar != nil {
+				bar.Finish()
+				bar = nil
+			}
+		}
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -3,10 +3,12 @@ package wallet
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 	"strconv"
 
@@ -16,7 +18,6 @@ import (
 
 	. "github.com/elastos/Elastos.ELA/core"
 	. "github.com/elastos/Elastos.ELA.Utility/common"
-	"github.com/elastos/Elastos.ELA.Utility/crypto"
 	"github.com/urfave/cli"
 )
 
@@ -32,7 +33,28 @@ func createTransaction(c *cli.Context, wallet walt.Wallet) error {
 		return errors.New("invalid transaction fee")
 	}
 
+	utxos, err := parseOutPoints(c.StringSlice("utxo"))
+	if err != nil {
+		return err
+	}
+
+	// The UTXOs are spent as they are synchronized to the chain height
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
+
 	from := c.String("from")
+	if from == "" && len(utxos) > 0 {
+		// Spend from the owner of the selected UTXOs
+		programHash, _, err := wallet.GetUTXO(utxos[0])
+		if err != nil {
+			return errors.New("UTXO " + walt.FormatOutPoint(utxos[0]) + " not found in wallet")
+		}
+		from, err = programHash.ToAddress()
+		if err != nil {
+			return err
+		}
+	}
 	if from == "" {
 		from, err = SelectAccount(wallet)
 		if err != nil {
@@ -42,7 +64,7 @@ func createTransaction(c *cli.Context, wallet walt.Wallet) error {
 
 	multiOutput := c.String("file")
 	if multiOutput != "" {
-		return createMultiOutputTransaction(c, wallet, multiOutput, from, fee)
+		return createMultiOutputTransaction(c, wallet, multiOutput, from, fee, utxos)
 	}
 
 	to := c.String("to")
@@ -62,7 +84,16 @@ func createTransaction(c *cli.Context, wallet walt.Wallet) error {
 
 	lockStr := c.String("lock")
 	var txn *Transaction
-	if lockStr == "" {
+	if len(utxos) > 0 {
+		lock, err := parseLock(lockStr)
+		if err != nil {
+			return err
+		}
+		txn, err = wallet.CreateCoinControlTransaction(from, fee, lock, utxos, &walt.Transfer{to, amount})
+		if err != nil {
+			return errors.New("create transaction failed: " + err.Error())
+		}
+	} else if lockStr == "" {
 		txn, err = wallet.CreateTransaction(from, to, amount, fee)
 		if err != nil {
 			return errors.New("create transaction failed: " + err.Error())
@@ -78,12 +109,29 @@ func createTransaction(c *cli.Context, wallet walt.Wallet) error {
 		}
 	}
 
-	output(0, 0, txn)
+	ptxn, err := wallet.ToPartialTransaction(txn, c.String("notes"))
+	if err != nil {
+		return errors.New("create partial transaction failed: " + err.Error())
+	}
+
+	output(0, 0, txn, ptxn)
 
 	return nil
 }
 
-func createMultiOutputTransaction(c *cli.Context, wallet walt.Wallet, path, from string, fee *Fixed64) error {
+// parseLock parses the --lock height, no lock is 0
+func parseLock(lockStr string) (uint32, error) {
+	if lockStr == "" {
+		return 0, nil
+	}
+	lock, err := strconv.ParseUint(lockStr, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid lock height")
+	}
+	return uint32(lock), nil
+}
+
+func createMultiOutputTransaction(c *cli.Context, wallet walt.Wallet, path, from string, fee *Fixed64, utxos []*OutPoint) error {
 	if _, err := os.Stat(path); err != nil {
 		return errors.New("invalid multi output file path")
 	}
@@ -111,7 +159,16 @@ func createMultiOutputTransaction(c *cli.Context, wallet walt.Wallet, path, from
 
 	lockStr := c.String("lock")
 	var txn *Transaction
-	if lockStr == "" {
+	if len(utxos) > 0 {
+		lock, err := parseLock(lockStr)
+		if err != nil {
+			return err
+		}
+		txn, err = wallet.CreateCoinControlTransaction(from, fee, lock, utxos, multiOutput...)
+		if err != nil {
+			return errors.New("create multi output transaction failed: " + err.Error())
+		}
+	} else if lockStr == "" {
 		txn, err = wallet.CreateMultiOutputTransaction(from, fee, multiOutput...)
 		if err != nil {
 			return errors.New("create multi output transaction failed: " + err.Error())
@@ -127,7 +184,12 @@ func createMultiOutputTransaction(c *cli.Context, wallet walt.Wallet, path, from
 		}
 	}
 
-	output(0, 0, txn)
+	ptxn, err := wallet.ToPartialTransaction(txn, c.String("notes"))
+	if err != nil {
+		return errors.New("create partial transaction failed: " + err.Error())
+	}
+
+	output(0, 0, txn, ptxn)
 
 	return nil
 }
@@ -135,24 +197,12 @@ func createMultiOutputTransaction(c *cli.Context, wallet walt.Wallet, path, from
 func signTransaction(name string, password []byte, context *cli.Context, wallet walt.Wallet) error {
 	defer ClearBytes(password)
 
-	content, err := getTransactionContent(context)
+	txn, ptxn, err := getTransaction(context, wallet)
 	if err != nil {
 		return err
 	}
-	rawData, err := HexStringToBytes(content)
-	if err != nil {
-		return errors.New("decode transaction content failed")
-	}
-
-	var txn Transaction
-	err = txn.Deserialize(bytes.NewReader(rawData))
-	if err != nil {
-		return errors.New("deserialize transaction failed")
-	}
-
-	program := txn.Programs[0]
 
-	haveSign, needSign, err := crypto.GetSignStatus(program.Code, program.Parameter)
+	haveSign, needSign := ptxn.SignStatus()
 	if haveSign == needSign {
 		return errors.New("transaction was fully signed, no need more sign")
 	}
@@ -162,25 +212,46 @@ func signTransaction(name string, password []byte, context *cli.Context, wallet
 		return err
 	}
 
-	_, err = wallet.Sign(name, password, &txn)
+	_, err = wallet.Sign(name, password, txn)
 	if err != nil {
 		return err
 	}
 
-	haveSign, needSign, _ = crypto.GetSignStatus(program.Code, program.Parameter)
+	err = ptxn.Update(txn)
+	if err != nil {
+		return err
+	}
+
+	for _, program := range ptxn.Programs {
+		fmt.Println("[", program.HaveSign, "/", program.NeedSign, "]", program.Address)
+	}
+	haveSign, needSign = ptxn.SignStatus()
 	fmt.Println("[", haveSign, "/", needSign, "] Transaction successfully signed")
 
-	output(haveSign, needSign, &txn)
+	output(haveSign, needSign, txn, ptxn)
 
 	return nil
 }
 
-func sendTransaction(context *cli.Context) error {
-	content, err := getTransactionContent(context)
+func sendTransaction(context *cli.Context, wallet walt.Wallet) error {
+	txn, _, err := getTransaction(context, wallet)
 	if err != nil {
 		return err
 	}
 
+	// Verify transaction locally before send it to the node
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
+	err = wallet.VerifyTransaction(txn)
+	if err != nil {
+		return errors.New("verify transaction failed: " + err.Error())
+	}
+
+	buf := new(bytes.Buffer)
+	txn.Serialize(buf)
+	content := BytesToHexString(buf.Bytes())
+
 	result, err := rpc.CallAndUnmarshal("sendrawtransaction", rpc.Param("data", content))
 	if err != nil {
 		return err
@@ -189,29 +260,133 @@ func sendTransaction(context *cli.Context) error {
 	return nil
 }
 
-func getTransactionContent(context *cli.Context) (string, error) {
+func verifyTransaction(context *cli.Context, wallet walt.Wallet) error {
+	txn, _, err := getTransaction(context, wallet)
+	if err != nil {
+		return err
+	}
 
-	// If parameter with file path is not empty, read content from file
-	if filePath := strings.TrimSpace(context.String("file")); filePath != "" {
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
+	err = wallet.VerifyTransaction(txn)
+	if err != nil {
+		return err
+	}
 
-		if _, err := os.Stat(filePath); err != nil {
-			return "", errors.New("invalid transaction file path")
-		}
-		file, err := os.OpenFile(filePath, os.O_RDONLY, 0666)
+	fmt.Println("Transaction verified successfully")
+	return nil
+}
+
+func combineTransactions(context *cli.Context, wallet walt.Wallet) error {
+	files := strings.Split(context.String("file"), ",")
+	if len(files) < 2 {
+		return errors.New("use --file to specify at least two transaction files separated by comma")
+	}
+
+	var txns []*Transaction
+	var ptxn *walt.PartialTransaction
+	for _, file := range files {
+		content, err := readTransactionFile(strings.TrimSpace(file))
 		if err != nil {
-			return "", errors.New("open transaction file failed")
+			return err
 		}
-		rawData, err := ioutil.ReadAll(file)
+		txn, partial, err := parseTransaction(content, wallet)
 		if err != nil {
-			return "", errors.New("read transaction file failed")
+			return err
 		}
+		// Every file must describe the same inputs with the same notes
+		if ptxn == nil {
+			ptxn = partial
+		} else if partial.Notes != ptxn.Notes || !reflect.DeepEqual(partial.Inputs, ptxn.Inputs) {
+			return errors.New("transaction file " + file + " has different inputs or notes")
+		}
+		txns = append(txns, txn)
+	}
+
+	txn, err := walt.CombineTransactions(txns...)
+	if err != nil {
+		return err
+	}
+
+	err = ptxn.Update(txn)
+	if err != nil {
+		return err
+	}
 
-		content := strings.TrimSpace(string(rawData))
-		// File content can not by empty
-		if content == "" {
-			return "", errors.New("transaction file is empty")
+	for _, program := range ptxn.Programs {
+		fmt.Println("[", program.HaveSign, "/", program.NeedSign, "]", program.Address)
+	}
+	haveSign, needSign := ptxn.SignStatus()
+	fmt.Println("[", haveSign, "/", needSign, "] Transaction successfully combined")
+
+	output(haveSign, needSign, txn, ptxn)
+
+	return nil
+}
+
+func getTransaction(context *cli.Context, wallet walt.Wallet) (*Transaction, *walt.PartialTransaction, error) {
+	content, err := getTransactionContent(context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseTransaction(content, wallet)
+}
+
+func parseTransaction(content string, wallet walt.Wallet) (*Transaction, *walt.PartialTransaction, error) {
+	// Partial transaction file carries the transaction in JSON format
+	if isPartialTransaction(content) {
+		ptxn, err := walt.ReadPartialTransaction([]byte(content))
+		if err != nil {
+			return nil, nil, errors.New("decode partial transaction failed: " + err.Error())
+		}
+		txn, err := ptxn.GetTransaction()
+		if err != nil {
+			return nil, nil, err
 		}
-		return content, nil
+		// Recalculate sign status from the signatures carried
+		err = ptxn.Update(txn)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The inputs to review come from the file creator, check them before they are shown or signed
+		err = wallet.CheckPartialTransaction(ptxn, txn)
+		if err != nil {
+			return nil, nil, errors.New("check partial transaction failed: " + err.Error())
+		}
+		return txn, ptxn, nil
+	}
+
+	// Legacy transaction file carries the transaction in hex string format
+	rawData, err := HexStringToBytes(content)
+	if err != nil {
+		return nil, nil, errors.New("decode transaction content failed")
+	}
+
+	var txn Transaction
+	err = txn.Deserialize(bytes.NewReader(rawData))
+	if err != nil {
+		return nil, nil, errors.New("deserialize transaction failed")
+	}
+
+	ptxn, err := wallet.ToPartialTransaction(&txn, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &txn, ptxn, nil
+}
+
+func isPartialTransaction(content string) bool {
+	return strings.HasPrefix(content, "{")
+}
+
+func getTransactionContent(context *cli.Context) (string, error) {
+
+	// If parameter with file path is not empty, read content from file
+	if filePath := strings.TrimSpace(context.String("file")); filePath != "" {
+		return readTransactionFile(filePath)
 	}
 
 	content := strings.TrimSpace(context.String("hex"))
@@ -223,7 +398,30 @@ func getTransactionContent(context *cli.Context) (string, error) {
 	return content, nil
 }
 
-func output(haveSign, needSign int, txn *Transaction) error {
+func readTransactionFile(filePath string) (string, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return "", errors.New("invalid transaction file path")
+	}
+	file, err := os.OpenFile(filePath, os.O_RDONLY, 0666)
+	if err != nil {
+		return "", errors.New("open transaction file failed")
+	}
+	defer file.Close()
+
+	rawData, err := ioutil.ReadAll(file)
+	if err != nil {
+		return "", errors.New("read transaction file failed")
+	}
+
+	content := strings.TrimSpace(string(rawData))
+	// File content can not by empty
+	if content == "" {
+		return "", errors.New("transaction file is empty")
+	}
+	return content, nil
+}
+
+func output(haveSign, needSign int, txn *Transaction, ptxn *walt.PartialTransaction) error {
 	// Serialise transaction content
 	buf := new(bytes.Buffer)
 	txn.Serialize(buf)
@@ -242,14 +440,28 @@ func output(haveSign, needSign int, txn *Transaction) error {
 	} else if needSign == haveSign {
 		fileName = "ready_to_send"
 	}
-	fileName = fileName + ".txn"
 
+	err := writeFile(fileName+".txn", []byte(content))
+	if err != nil {
+		return err
+	}
+
+	// Output partial transaction along with the legacy hex file
+	data, err := json.MarshalIndent(ptxn, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFile(fileName+walt.PartialTransactionFileExt, data)
+}
+
+func writeFile(fileName string, data []byte) error {
 	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	_, err = file.Write([]byte(content))
+	_, err = file.Write(data)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,150 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	walt "github.com/elastos/Elastos.ELA.Client/wallet"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+	"github.com/urfave/cli"
+)
+
+func listUnspent(context *cli.Context, wallet walt.Wallet) error {
+	var addresses []*walt.Address
+	if address := context.String("address"); address != "" {
+		programHash, err := Uint168FromAddress(address)
+		if err != nil {
+			return errors.New("invalid address " + address)
+		}
+		addr, err := wallet.GetAddressInfo(programHash)
+		if err != nil {
+			return errors.New("address " + address + " is not in this wallet")
+		}
+		addresses = append(addresses, addr)
+	}
+
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		var err error
+		addresses, err = wallet.GetAddresses()
+		if err != nil {
+			return errors.New("get wallet addresses failed")
+		}
+	}
+
+	// print header
+	fmt.Printf("%-70s %20s %8s %13s %-34s %-6s %s\n", "OUTPOINT", "AMOUNT", "LOCK", "CONFIRMATIONS", "ADDRESS",
+		"FROZEN", "LABEL")
+	fmt.Println(strings.Repeat("-", 70), strings.Repeat("-", 20), "--------", strings.Repeat("-", 13),
+		strings.Repeat("-", 34), "------", strings.Repeat("-", 20))
+
+	currentHeight := wallet.CurrentHeight(walt.QueryHeightCode)
+	var count int
+	var total Fixed64
+	for _, addr := range addresses {
+		UTXOs, err := wallet.GetAddressUTXOs(addr.ProgramHash)
+		if err != nil {
+			return errors.New("get " + addr.Address + " UTXOs failed")
+		}
+		// Oldest first
+		sort.Slice(UTXOs, func(i, j int) bool {
+			return UTXOs[i].Height < UTXOs[j].Height
+		})
+		for _, utxo := range UTXOs {
+			var confirmations uint32
+			if currentHeight > utxo.Height {
+				confirmations = currentHeight - utxo.Height
+			}
+			var frozen string
+			if utxo.Frozen {
+				frozen = "yes"
+			}
+			fmt.Printf("%-70s %20s %8d %13d %-34s %-6s %s\n", walt.FormatOutPoint(utxo.Op), utxo.Amount.String(),
+				utxo.LockTime, confirmations, addr.Address, frozen, utxo.Label)
+			count++
+			total += *utxo.Amount
+		}
+	}
+	fmt.Println(strings.Repeat("-", 70), strings.Repeat("-", 20), "--------", strings.Repeat("-", 13),
+		strings.Repeat("-", 34), "------", strings.Repeat("-", 20))
+	fmt.Println("UTXOs:", count, "Total:", total.String())
+
+	return nil
+}
+
+func freezeUTXOs(wallet walt.Wallet, value string, frozen bool) error {
+	ops, err := parseOutPoints([]string{value})
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		return errors.New("no UTXO given")
+	}
+	for _, op := range ops {
+		if err := wallet.SetUTXOFrozen(op, frozen); err != nil {
+			return errors.New("UTXO " + walt.FormatOutPoint(op) + " not updated, " + err.Error())
+		}
+	}
+	if frozen {
+		fmt.Println(len(ops), "UTXOs frozen")
+	} else {
+		fmt.Println(len(ops), "UTXOs unfrozen")
+	}
+	return nil
+}
+
+func labelUTXOs(context *cli.Context, wallet walt.Wallet) error {
+	ops, err := parseOutPoints(context.StringSlice("utxo"))
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		return errors.New("use --utxo to specify the UTXOs to label")
+	}
+	label := context.String("label")
+	for _, op := range ops {
+		if err := wallet.SetUTXOLabel(op, label); err != nil {
+			return errors.New("UTXO " + walt.FormatOutPoint(op) + " not updated, " + err.Error())
+		}
+	}
+	fmt.Println(len(ops), "UTXOs labeled")
+	return nil
+}
+
+// parseOutPoints parses the --utxo values, each value can hold several txid:index separated by comma
+func parseOutPoints(values []string) ([]*OutPoint, error) {
+	var ops []*OutPoint
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			parts := strings.Split(item, ":")
+			if len(parts) != 2 {
+				return nil, errors.New("invalid UTXO " + item + ", use txid:index format")
+			}
+			txIDBytes, err := HexStringToBytes(parts[0])
+			if err != nil {
+				return nil, errors.New("invalid UTXO transaction ID " + parts[0])
+			}
+			txID, err := Uint256FromBytes(txIDBytes)
+			if err != nil {
+				return nil, errors.New("invalid UTXO transaction ID " + parts[0])
+			}
+			index, err := strconv.ParseUint(parts[1], 10, 16)
+			if err != nil {
+				return nil, errors.New("invalid UTXO index " + parts[1])
+			}
+			ops = append(ops, NewOutPoint(*txID, uint16(index)))
+		}
+	}
+	return ops, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"errors"
 
+	"github.com/elastos/Elastos.ELA.Client/config"
 	"github.com/elastos/Elastos.ELA.Client/wallet"
 	"github.com/elastos/Elastos.ELA.Client/log"
 
@@ -60,10 +61,11 @@ func createWallet(name string, password []byte) error {
 		return err
 	}
 
-	_, err = wallet.Create(name, password)
+	created, err := wallet.Create(name, password)
 	if err != nil {
 		return err
 	}
+	created.Close()
 
 	return ShowAccountInfo(name, password)
 }
@@ -96,8 +98,44 @@ func changePassword(name string, password []byte, wallet wallet.Wallet) error {
 	return nil
 }
 
+func encryptWallet(name string, password []byte, wallet wallet.Wallet) error {
+	password, err := GetPassword(password, false)
+	if err != nil {
+		return err
+	}
+	// The data is encrypted with the wallet password
+	err = wallet.Open(name, password)
+	if err != nil {
+		return err
+	}
+	err = wallet.EncryptDataStore(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wallet data encrypted, the password is required to open it from now on")
+	return nil
+}
+
+func listWallets() error {
+	names, err := config.Wallets()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Data directory:", config.DataDir())
+	for _, name := range names {
+		if name == config.WalletName() {
+			fmt.Println("*", name)
+		} else {
+			fmt.Println(" ", name)
+		}
+	}
+	return nil
+}
+
 func listBalanceInfo(wallet wallet.Wallet) error {
-	wallet.SyncChainData()
+	if err := syncChainData(wallet); err != nil {
+		return err
+	}
 	addresses, err := wallet.GetAddresses()
 	if err != nil {
 		log.Error("Get addresses error:", err)
@@ -115,6 +153,22 @@ func walletAction(context *cli.Context) {
 	name := context.String("name")
 	pass := context.String("password")
 
+	// select wallet
+	walletName := context.String("wallet")
+	if err := config.SetWalletName(walletName); err != nil {
+		fmt.Println("error:", err)
+		cli.ShowCommandHelpAndExit(context, "wallet", 11)
+	}
+
+	// list wallets
+	if context.Bool("wallets") {
+		if err := listWallets(); err != nil {
+			fmt.Println("error: list wallets failed,", err)
+			os.Exit(11)
+		}
+		return
+	}
+
 	// import wallet from an exited private key
 	if privateKey := context.String("import"); len(privateKey) > 0 {
 		if err := importKeystore(name, []byte(pass), privateKey); err != nil {
@@ -142,55 +196,190 @@ func walletAction(context *cli.Context) {
 		return
 	}
 
+	// restore wallet from a backup archive
+	if fileName := context.String("restore"); fileName != "" {
+		if err := restoreWallet(fileName, []byte(pass)); err != nil {
+			fmt.Println("error: restore wallet failed,", err)
+			cli.ShowCommandHelpAndExit(context, "restore", 16)
+		}
+		return
+	}
+
+	if walletName != "" && !config.WalletExists(walletName) {
+		fmt.Println("error: wallet", walletName, "not found, use --create or --import to create it")
+		os.Exit(11)
+	}
+
 	wallet, err := wallet.GetWallet()
 	if err != nil {
 		fmt.Println("error: open wallet failed, ", err)
 		os.Exit(2)
 	}
+	code := runWalletCommand(context, wallet, name, pass)
+	// The encrypted wallet data is saved when it's closed, so close it before exiting
+	wallet.Close()
+	if code != nil {
+		if code.help != "" {
+			cli.ShowCommandHelpAndExit(context, code.help, code.code)
+		}
+		os.Exit(code.code)
+	}
+}
+
+// commandError is the exit code of a failed command, and the command to show the help of
+type commandError struct {
+	help string
+	code int
+}
+
+// runWalletCommand runs the command on the opened wallet, it returns nil if the command succeeds
+func runWalletCommand(context *cli.Context, wallet wallet.Wallet, name, pass string) *commandError {
+	// report the synchronization progress
+	wallet.SetProgressHandler(newProgressHandler(context.Bool("quiet")))
+
+	// unlock the encrypted wallet data, the password is not asked again by the command
+	if wallet.IsDataLocked() {
+		password, err := GetPassword([]byte(pass), false)
+		if err != nil {
+			fmt.Println("error: get password failed,", err)
+			return &commandError{"", 15}
+		}
+		if err := wallet.UnlockData(password); err != nil {
+			fmt.Println("error: unlock wallet data failed,", err)
+			return &commandError{"", 15}
+		}
+		pass = string(password)
+	}
 
 	// show account info
 	if context.Bool("account") {
 		if err := ShowAccountInfo(name, []byte(pass)); err != nil {
 			fmt.Println("error: show account info failed,", err)
-			cli.ShowCommandHelpAndExit(context, "account", 3)
+			return &commandError{"account", 3}
 		}
-		return
+		return nil
 	}
 
 	// change password
 	if context.Bool("changepassword") {
 		if err := changePassword(name, []byte(pass), wallet); err != nil {
 			fmt.Println("error: change password failed,", err)
-			cli.ShowCommandHelpAndExit(context, "changepassword", 4)
+			return &commandError{"changepassword", 4}
 		}
-		return
+		return nil
+	}
+
+	// backup the wallet to an archive
+	if fileName := context.String("backup"); fileName != "" {
+		if err := backupWallet(name, []byte(pass), wallet, fileName, context.Bool("encrypt")); err != nil {
+			fmt.Println("error: backup wallet failed,", err)
+			return &commandError{"backup", 16}
+		}
+		return nil
+	}
+
+	// encrypt the wallet data
+	if context.Bool("encrypt") {
+		if err := encryptWallet(name, []byte(pass), wallet); err != nil {
+			fmt.Println("error: encrypt wallet data failed,", err)
+			return &commandError{"encrypt", 15}
+		}
+		return nil
 	}
 
 	// add an account
 	if input := context.String("addaccount"); input != "" {
 		if err := addAccount(context, wallet, input); err != nil {
 			fmt.Println("error: add standard account failed,", err)
-			cli.ShowCommandHelpAndExit(context, "addaccount", 5)
+			return &commandError{"addaccount", 5}
 		}
-		return
+		return nil
 	}
 
 	// delete account
 	if address := context.String("delaccount"); address != "" {
 		if err := deleteAccount(wallet, address); err != nil {
 			fmt.Println("error: delete account failed,", err)
-			cli.ShowCommandHelpAndExit(context, "delaccount", 5)
+			return &commandError{"delaccount", 5}
 		}
-		return
+		return nil
 	}
 
 	// list accounts information
 	if context.Bool("list") {
 		if err := listBalanceInfo(wallet); err != nil {
 			fmt.Println("error: list accounts information failed,", err)
-			cli.ShowCommandHelpAndExit(context, "list", 6)
+			return &commandError{"list", 6}
 		}
-		return
+		return nil
+	}
+
+	// show balance at a past height or date
+	if value := context.String("balance-at"); value != "" {
+		if err := showBalanceAt(wallet, value); err != nil {
+			fmt.Println("error: show balance failed,", err)
+			return &commandError{"balance-at", 14}
+		}
+		return nil
+	}
+
+	// check the wallet data against the node
+	if context.Bool("check") {
+		if err := checkWallet(wallet, context.Bool("repair")); err != nil {
+			fmt.Println("error: check wallet failed,", err)
+			return &commandError{"check", 17}
+		}
+		return nil
+	}
+
+	// list unspent transaction outputs
+	if context.Bool("listunspent") {
+		if err := listUnspent(context, wallet); err != nil {
+			fmt.Println("error: list unspent transaction outputs failed,", err)
+			return &commandError{"listunspent", 12}
+		}
+		return nil
+	}
+
+	// freeze, unfreeze or label UTXOs
+	if value := context.String("freeze"); value != "" {
+		if err := freezeUTXOs(wallet, value, true); err != nil {
+			fmt.Println("error: freeze UTXOs failed,", err)
+			return &commandError{"freeze", 13}
+		}
+		return nil
+	}
+	if value := context.String("unfreeze"); value != "" {
+		if err := freezeUTXOs(wallet, value, false); err != nil {
+			fmt.Println("error: unfreeze UTXOs failed,", err)
+			return &commandError{"unfreeze", 13}
+		}
+		return nil
+	}
+	if context.IsSet("label") {
+		if err := labelUTXOs(context, wallet); err != nil {
+			fmt.Println("error: label UTXOs failed,", err)
+			return &commandError{"label", 13}
+		}
+		return nil
+	}
+
+	// show transaction history
+	if context.Bool("history") {
+		if err := showHistory(context, wallet); err != nil {
+			fmt.Println("error: show transaction history failed,", err)
+			return &commandError{"history", 9}
+		}
+		return nil
+	}
+
+	// export transaction history
+	if fileName := context.String("export-history"); fileName != "" {
+		if err := exportHistory(context, wallet, fileName); err != nil {
+			fmt.Println("error: export transaction history failed,", err)
+			return &commandError{"export-history", 10}
+		}
+		return nil
 	}
 
 	// transaction actions
@@ -199,33 +388,45 @@ func walletAction(context *cli.Context) {
 		case "create":
 			if err := createTransaction(context, wallet); err != nil {
 				fmt.Println("error:", err)
-				os.Exit(701)
+				return &commandError{"", 701}
 			}
 		case "sign":
 			if err := signTransaction(name, []byte(pass), context, wallet); err != nil {
 				fmt.Println("error:", err)
-				os.Exit(702)
+				return &commandError{"", 702}
 			}
 		case "send":
-			if err := sendTransaction(context); err != nil {
+			if err := sendTransaction(context, wallet); err != nil {
+				fmt.Println("error:", err)
+				return &commandError{"", 703}
+			}
+		case "combine":
+			if err := combineTransactions(context, wallet); err != nil {
+				fmt.Println("error:", err)
+				return &commandError{"", 704}
+			}
+		case "verify":
+			if err := verifyTransaction(context, wallet); err != nil {
 				fmt.Println("error:", err)
-				os.Exit(703)
+				return &commandError{"", 705}
 			}
 		default:
-			cli.ShowCommandHelpAndExit(context, "transaction", 700)
+			return &commandError{"transaction", 700}
 		}
-		return
+		return nil
 	}
 
 	// reset wallet
 	if context.Bool("reset") {
 		if err := wallet.Reset(); err != nil {
 			fmt.Println("error: reset wallet data store failed,", err)
-			cli.ShowCommandHelpAndExit(context, "reset", 8)
+			return &commandError{"reset", 8}
 		}
 		fmt.Println("wallet data store was reset successfully")
-		return
+		return nil
 	}
+
+	return nil
 }
 
 func NewCommand() *cli.Command {
@@ -239,9 +440,24 @@ func NewCommand() *cli.Command {
 				Name:  "password, p",
 				Usage: "arguments to pass the password value",
 			},
+			cli.StringFlag{
+				Name:   "wallet",
+				Usage:  "the name of the wallet to use, each wallet has it's own keystore files and database",
+				EnvVar: "ELA_CLI_WALLET",
+			},
+			cli.BoolFlag{
+				Name:  "wallets",
+				Usage: "list the wallets in the data directory",
+			},
+			cli.BoolFlag{
+				Name:  "quiet, q",
+				Usage: "do not report the synchronization progress, which is a progress bar on a terminal,\n" +
+					"\tor JSON lines on stderr otherwise",
+			},
 			cli.StringFlag{
 				Name:  "name, n",
-				Usage: "to specify the created keystore file name or the keystore file path to open",
+				Usage: "to specify the created keystore file name or the keystore file path to open,\n" +
+					"\ta file name without directory is in the directory of the selected wallet",
 				Value: wallet.DefaultKeystoreFile,
 			},
 			cli.StringFlag{
@@ -264,15 +480,32 @@ func NewCommand() *cli.Command {
 				Name:  "changepassword",
 				Usage: "change the password to access this wallet, must do not forget it",
 			},
+			cli.BoolFlag{
+				Name: "encrypt",
+				Usage: "encrypt the wallet data with the wallet password, the plaintext database is removed\n" +
+					"\tthe password is required by every command from then on\n" +
+					"\twith --backup, encrypt the backup archive with the wallet password instead",
+			},
+			cli.StringFlag{
+				Name: "backup",
+				Usage: "backup the keystores, addresses, UTXO labels and transaction history to an archive file\n" +
+					"\tuse [--encrypt] to encrypt the archive with the wallet password, which is the default for encrypted wallet data",
+			},
+			cli.StringFlag{
+				Name: "restore",
+				Usage: "restore the wallet from an archive file created by --backup to the selected wallet,\n" +
+					"\tthe keystores are verified with the password against the addresses in the archive",
+			},
 			cli.BoolFlag{
 				Name:  "reset",
-				Usage: "clear the UTXOs stored in the local database",
+				Usage: "clear the data synchronized from the chain in the local database, the accounts are kept",
 			},
 			cli.StringFlag{
 				Name: "addaccount",
 				Usage: "add a standard account with a public key, or add a multi-sign account with multiple public keys\n" +
 					"\tuse -m to specify how many signatures are needed to create a valid transaction\n" +
-					"\tby default M is public keys / 2 + 1, witch means greater than half",
+					"\tby default M is public keys / 2 + 1, witch means greater than half\n" +
+					"\tuse [--from-height] to specify the block height from which the account may have transactions",
 			},
 			cli.IntFlag{
 				Name:  "m",
@@ -287,19 +520,109 @@ func NewCommand() *cli.Command {
 				Name:  "list, l",
 				Usage: "list accounts information, including address, public key, balance and account type.",
 			},
+			cli.StringFlag{
+				Name: "balance-at",
+				Usage: "show the balance of the wallet addresses at the given block height, or the end of the date in " +
+					DateFormat + " format\n" +
+					"\ta warning is shown if the wallet was synced before spent UTXOs were recorded, use --reset to resync",
+			},
+			cli.BoolFlag{
+				Name: "check",
+				Usage: "check the stored UTXOs and balances against the unspent outputs indexed by the node,\n" +
+					"\tand the records of the local database, use [--repair] to fix the discrepancies in the local database",
+			},
+			cli.BoolFlag{
+				Name:  "repair",
+				Usage: "with --check, fix the discrepancies in the local database",
+			},
+			cli.BoolFlag{
+				Name: "listunspent",
+				Usage: "list the UTXOs of the wallet addresses, including outpoint, amount, lock height, confirmations and address\n" +
+					"\tuse [--address] to list the UTXOs of one address",
+			},
+			cli.StringFlag{
+				Name: "freeze",
+				Usage: "freeze the UTXOs in txid:index format separated by comma,\n" +
+					"\tfrozen UTXOs are not spent unless they are given by --utxo",
+			},
+			cli.StringFlag{
+				Name:  "unfreeze",
+				Usage: "unfreeze the UTXOs in txid:index format separated by comma",
+			},
+			cli.StringFlag{
+				Name:  "label",
+				Usage: "set the label of the UTXOs given by --utxo, an empty label clears it",
+			},
+			cli.BoolFlag{
+				Name: "history",
+				Usage: "show transaction history of the wallet addresses\n" +
+					"\tuse [--address] [--from-height] [--to-height] [--from-date] [--to-date] to filter the records\n" +
+					"\tuse [--page] [--pagesize] to page through the records",
+			},
+			cli.StringFlag{
+				Name: "export-history",
+				Usage: "export transaction history with running balance to the given file\n" +
+					"\tuse [--format] to specify the file format, or by the file extension\n" +
+					"\tuse [--address] [--from-height] [--to-height] [--from-date] [--to-date] to filter the records",
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "the export file format [csv, json, ofx], json means JSON lines",
+			},
+			cli.StringFlag{
+				Name:  "address",
+				Usage: "the wallet address to filter the records",
+			},
+			cli.UintFlag{
+				Name:  "from-height",
+				Usage: "the lowest block height of the records, or the birthday height of the account to add",
+			},
+			cli.UintFlag{
+				Name:  "to-height",
+				Usage: "the highest block height of the records",
+			},
+			cli.StringFlag{
+				Name:  "from-date",
+				Usage: "the earliest date of the records in " + DateFormat + " format",
+			},
+			cli.StringFlag{
+				Name:  "to-date",
+				Usage: "the latest date of the records in " + DateFormat + " format",
+			},
+			cli.IntFlag{
+				Name:  "page",
+				Usage: "the page number of the records",
+				Value: 1,
+			},
+			cli.IntFlag{
+				Name:  "pagesize",
+				Usage: "how many records to show in a page",
+				Value: DefaultPageSize,
+			},
 			cli.StringFlag{
 				Name: "transaction, t",
-				Usage: "use [create, sign, send], to create, sign or send a transaction\n" +
+				Usage: "use [create, sign, send, combine, verify], to create, sign, send, combine or verify a transaction\n" +
 					"\tcreate:\n" +
-					"\t\tuse --to --amount --fee [--lock], or --file --fee [--lock]\n" +
+					"\t\tuse --to --amount --fee [--lock] [--notes], or --file --fee [--lock] [--notes]\n" +
 					"\t\tto create a standard transaction, or multi output transaction\n" +
-					"\tsign, send:\n" +
-					"\t\tuse --file or --hex to specify the transaction file path or content\n",
+					"\t\tuse [--utxo] to spend exactly the given UTXOs instead of selecting them automatically\n" +
+					"\tsign, send, verify:\n" +
+					"\t\tuse --file or --hex to specify the transaction file path or content\n" +
+					"\t\tthe file can be a legacy .txn hex file or a " + wallet.PartialTransactionFileExt + " partial transaction file\n" +
+					"\t\ttransaction is verified locally before send\n" +
+					"\tcombine:\n" +
+					"\t\tuse --file to specify the copies of a multi sign transaction signed by different cosigners,\n" +
+					"\t\tseparated by comma, to merge their signatures into one transaction\n",
 			},
 			cli.StringFlag{
 				Name:  "from",
 				Usage: "the spend address of the transaction",
 			},
+			cli.StringSliceFlag{
+				Name: "utxo",
+				Usage: "the UTXO to spend in txid:index format, repeat it or separate by comma to spend more UTXOs,\n" +
+					"\tthe spend address is the owner of the UTXOs when --from is not given, frozen UTXOs can be spent this way",
+			},
 			cli.StringFlag{
 				Name:  "to",
 				Usage: "the receive address of the transaction",
@@ -316,6 +639,10 @@ func NewCommand() *cli.Command {
 				Name:  "lock",
 				Usage: "the lock time to specify when the received asset can be spent",
 			},
+			cli.StringFlag{
+				Name:  "notes",
+				Usage: "the notes from the creator to be carried in the partial transaction file",
+			},
 			cli.StringFlag{
 				Name:  "hex",
 				Usage: "the transaction content in hex string format to be sign or send",
@@ -5,20 +5,67 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 )
 
 const (
-	ConfigFilename = "./cli-config.json"
+	ConfigFilename     = "cli-config.json"
+	DefaultSyncWorkers = 4
+)
+
+const (
+	// Scan every block for the transactions of the wallet addresses
+	SyncModeBlocks = "blocks"
+	// Query the unspent outputs of the wallet addresses indexed by the node
+	SyncModeUnspent = "unspent"
 )
 
 var config *Config // The single instance of config
 
 type Config struct {
-	Host string `json:"Host"`
+	Host        string `json:"Host"`
+	Network     string `json:"Network"`
+	SyncWorkers int    `json:"SyncWorkers"`
+	// Storage backend of the wallet data [sqlite, bolt, memory]
+	Storage string `json:"Storage"`
+	// How the wallet data is synchronized [blocks, unspent]
+	SyncMode string `json:"SyncMode"`
+	// Notifications of the wallet events found by synchronization
+	Notify NotifyConfig `json:"Notify"`
+}
+
+type NotifyConfig struct {
+	// Transactions reaching this number of confirmations fire the confirmed event, 0 disables it
+	Confirmations uint32          `json:"Confirmations"`
+	Webhooks      []WebhookConfig `json:"Webhooks"`
+	Hooks         []HookConfig    `json:"Hooks"`
+}
+
+// WebhookConfig is an URL the events are posted to in JSON
+type WebhookConfig struct {
+	URL string `json:"URL"`
+	// The key of the HMAC-SHA256 signature of the body, sent in the X-ELA-Signature header
+	Secret string `json:"Secret"`
+	// The events to post [incoming, outgoing, confirmed, block], all of them when empty
+	Events []string `json:"Events"`
+	// Retries of a failed delivery, the default is used when it's 0
+	Retries int `json:"Retries"`
 }
 
+// HookConfig is a local executable run with the event name as argument and the event JSON on stdin
+type HookConfig struct {
+	Path string `json:"Path"`
+	// The events to run the hook for, all of them when empty
+	Events []string `json:"Events"`
+}
+
+// readConfigFile reads the config file in the data directory, or in the working directory
 func (config *Config) readConfigFile() error {
-	data, err := ioutil.ReadFile(ConfigFilename)
+	data, err := ioutil.ReadFile(filepath.Join(DataDir(), ConfigFilename))
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(ConfigFilename)
+	}
 	if err != nil {
 		return err
 	}
@@ -34,11 +81,32 @@ func (config *Config) readConfigFile() error {
 
 func Params() *Config {
 	if config == nil {
-		config = &Config{"localhost:20336"}
+		config = &Config{Network: MainNet}
 		err := config.readConfigFile()
 		if err != nil {
 			fmt.Println("Read config file error:", err)
 		}
+		switch config.Network {
+		case MainNet, TestNet, RegTest:
+		default:
+			fmt.Println("Unknown network", config.Network, "in config file, use", MainNet)
+			config.Network = MainNet
+		}
+		// Use default port of the selected network
+		if config.Host == "" {
+			config.Host = fmt.Sprint("localhost:", Network().DefaultPort)
+		}
+		if config.SyncWorkers <= 0 {
+			config.SyncWorkers = DefaultSyncWorkers
+		}
+		switch config.SyncMode {
+		case SyncModeBlocks, SyncModeUnspent:
+		case "":
+			config.SyncMode = SyncModeBlocks
+		default:
+			fmt.Println("Unknown sync mode", config.SyncMode, "in config file, use", SyncModeBlocks)
+			config.SyncMode = SyncModeBlocks
+		}
 	}
 	return config
 }
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"math"
+)
+
+const (
+	MainNet = "mainnet"
+	TestNet = "testnet"
+	RegTest = "regtest"
+)
+
+// The consensus rules the wallet depends on are the same on every network
+const (
+	// How many blocks a coinbase output is locked after it was mined
+	CoinbaseMaturity = 100
+	// The input sequence required to spend a locked output
+	LockedInputSequence = math.MaxUint32 - 1
+	// Address prefixes accepted as transaction receiver
+	StandardPrefix   = 0x21
+	MultiSigPrefix   = 0x12
+	CrossChainPrefix = 0x4B
+)
+
+// NetParams are the parameters that differ between networks
+type NetParams struct {
+	Name string
+	// The default JSON RPC port of the node
+	DefaultPort int
+}
+
+var MainNetParams = NetParams{
+	Name:        MainNet,
+	DefaultPort: 20336,
+}
+
+var TestNetParams = NetParams{
+	Name:        TestNet,
+	DefaultPort: 21336,
+}
+
+var RegTestParams = NetParams{
+	Name:        RegTest,
+	DefaultPort: 22336,
+}
+
+func IsValidPrefix(prefix byte) bool {
+	return prefix == StandardPrefix ||
+		prefix == MultiSigPrefix ||
+		prefix == CrossChainPrefix
+}
+
+// Network returns the parameters of the network selected in config file
+func Network() *NetParams {
+	switch Params().Network {
+	case TestNet:
+		return &TestNetParams
+	case RegTest:
+		return &RegTestParams
+	default:
+		return &MainNetParams
+	}
+}
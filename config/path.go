@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DataDirEnv        = "ELA_CLI_DATADIR"
+	DataDirName       = "ela-cli"
+	WalletsDirName    = "wallets"
+	DefaultWalletName = "default"
+)
+
+// LegacyWalletFiles are the files of a wallet saved in the working directory by older versions
+var LegacyWalletFiles = []string{"wallet.db", "wallet.bolt", "wallet.enc", "keystore.dat", "wallet.dat"}
+
+var (
+	dataDir    string
+	walletName = DefaultWalletName
+	// The default wallet is in the data directory itself, as older versions saved it
+	legacyLayout bool
+)
+
+// SetDataDir sets the data directory, an empty dir selects the default one.
+func SetDataDir(dir string) {
+	dataDir = dir
+	legacyLayout = false
+}
+
+// DataDir returns the directory of the config file and wallets, in the order of
+// the directory set by SetDataDir, the ELA_CLI_DATADIR environment variable,
+// the working directory if it holds a wallet of older versions, and $XDG_DATA_HOME/ela-cli.
+func DataDir() string {
+	if dataDir != "" {
+		return dataDir
+	}
+	if dir := os.Getenv(DataDirEnv); dir != "" {
+		dataDir = dir
+		return dataDir
+	}
+	for _, file := range LegacyWalletFiles {
+		if _, err := os.Stat(file); err == nil {
+			dataDir = "."
+			legacyLayout = true
+			return dataDir
+		}
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			home = "."
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	dataDir = filepath.Join(dataHome, DataDirName)
+	return dataDir
+}
+
+// SetWalletName selects the wallet to use, an empty name selects the default wallet.
+func SetWalletName(name string) error {
+	if name == "" {
+		name = DefaultWalletName
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return errors.New("invalid wallet name " + name)
+	}
+	walletName = name
+	return nil
+}
+
+func WalletName() string {
+	return walletName
+}
+
+// WalletDir returns the directory of the keystore files and database of the selected wallet.
+func WalletDir() string {
+	return walletDir(walletName)
+}
+
+func walletDir(name string) string {
+	dir := DataDir()
+	if name == DefaultWalletName && legacyLayout {
+		return dir
+	}
+	return filepath.Join(dir, WalletsDirName, name)
+}
+
+// WalletExists returns if the named wallet has been created.
+func WalletExists(name string) bool {
+	_, err := os.Stat(walletDir(name))
+	return err == nil
+}
+
+// Wallets returns the names of the wallets in the data directory.
+func Wallets() ([]string, error) {
+	dir := DataDir()
+	var names []string
+	if legacyLayout {
+		names = append(names, DefaultWalletName)
+	}
+	dirs, err := filepath.Glob(filepath.Join(dir, WalletsDirName, "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		name := filepath.Base(dir)
+		// The default wallet of older versions takes the place of it's directory
+		if legacyLayout && name == DefaultWalletName {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
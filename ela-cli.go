@@ -4,6 +4,7 @@ import (
 	"os"
 	"sort"
 
+	"github.com/elastos/Elastos.ELA.Client/config"
 	"github.com/elastos/Elastos.ELA.Client/cli/info"
 	"github.com/elastos/Elastos.ELA.Client/cli/wallet"
 	"github.com/elastos/Elastos.ELA.Client/cli/mine"
@@ -27,6 +28,17 @@ func main() {
 	app.UsageText = "ela-cli [global options] command [command options] [args]"
 	app.HideHelp = false
 	app.HideVersion = false
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "datadir",
+			Usage:  "the directory of the config file and wallets, by default $XDG_DATA_HOME/ela-cli",
+			EnvVar: config.DataDirEnv,
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		config.SetDataDir(c.GlobalString("datadir"))
+		return nil
+	}
 	//commands
 	app.Commands = []cli.Command{
 		*cliLog.NewCommand(),
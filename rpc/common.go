@@ -50,6 +50,16 @@ type TransactionInfo struct {
 	Programs       []ProgramInfo   `json:"programs"`
 }
 
+type UTXOInfo struct {
+	AssetID       string `json:"assetid"`
+	TxID          string `json:"txid"`
+	VOut          uint32 `json:"vout"`
+	Address       string `json:"address"`
+	Amount        string `json:"amount"`
+	Confirmations uint32 `json:"confirmations"`
+	OutputLock    uint32 `json:"outputlock"`
+}
+
 type BlockInfo struct {
 	Hash              string        `json:"hash"`
 	Confirmations     uint32        `json:"confirmations"`
@@ -26,6 +26,25 @@ type Error struct {
 	Message string `json:"message"`
 }
 
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// IsNodeError returns if err is responded by the node, other errors are from the connection or the response format
+func IsNodeError(err error) bool {
+	_, ok := err.(*Error)
+	return ok
+}
+
+// ErrCodeMethodNotFound is the error code responded for a method the node does not provide
+const ErrCodeMethodNotFound = -32601
+
+// IsMethodNotFound returns if err is responded by the node for a method it does not provide
+func IsMethodNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code == ErrCodeMethodNotFound
+}
+
 var url string
 
 func GetChainHeight() (uint32, error) {
@@ -33,7 +52,11 @@ func GetChainHeight() (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
-	return uint32(result.(float64))-1, nil
+	count, ok := result.(float64)
+	if !ok {
+		return 0, errors.New(fmt.Sprint("invalid block count ", result))
+	}
+	return uint32(count)-1, nil
 }
 
 func GetBlockHash(height uint32) (*common.Uint256, error) {
@@ -41,8 +64,12 @@ func GetBlockHash(height uint32) (*common.Uint256, error) {
 	if err != nil {
 		return nil, err
 	}
+	hash, ok := result.(string)
+	if !ok {
+		return nil, errors.New(fmt.Sprint("invalid block hash ", result))
+	}
 
-	hashBytes, err := common.HexStringToBytes(result.(string))
+	hashBytes, err := common.HexStringToBytes(hash)
 	if err != nil {
 		return nil, err
 	}
@@ -56,11 +83,44 @@ func GetBlock(hash *common.Uint256) (*BlockInfo, error) {
 		return nil, err
 	}
 	block := &BlockInfo{}
-	unmarshal(&resp, block)
+	err = unmarshal(&resp, block)
+	if err != nil {
+		return nil, err
+	}
 
 	return block, nil
 }
 
+func GetTransaction(txID string) (*TransactionInfo, error) {
+	resp, err := CallAndUnmarshal("getrawtransaction",
+		Param("txid", txID).Add("verbose", true))
+	if err != nil {
+		return nil, err
+	}
+	txn := &TransactionInfo{}
+	err = unmarshal(&resp, txn)
+	if err != nil {
+		return nil, err
+	}
+
+	return txn, nil
+}
+
+func ListUnspent(address string) ([]UTXOInfo, error) {
+	resp, err := CallAndUnmarshal("listunspent",
+		Param("addresses", []string{address}))
+	if err != nil {
+		return nil, err
+	}
+	var utxos []UTXOInfo
+	err = unmarshal(&resp, &utxos)
+	if err != nil {
+		return nil, err
+	}
+
+	return utxos, nil
+}
+
 func Call(method string, params map[string]interface{}) ([]byte, error) {
 	if url == "" {
 		url = "http://" + config.Params().Host
@@ -76,7 +136,6 @@ func Call(method string, params map[string]interface{}) ([]byte, error) {
 	//fmt.Println("Request:", string(data))
 	resp, err := http.Post(url, "application/json", strings.NewReader(string(data)))
 	if err != nil {
-		fmt.Printf("POST requset: %v\n", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -103,7 +162,7 @@ func CallAndUnmarshal(method string, params map[string]interface{}) (interface{}
 	}
 
 	if resp.Error != nil {
-		return nil, errors.New(resp.Error.Message)
+		return nil, resp.Error
 	}
 
 	return resp.Result, nil
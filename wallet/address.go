@@ -1,6 +1,10 @@
 package wallet
 
-import . "github.com/elastos/Elastos.ELA.Utility/common"
+import (
+	"math"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+)
 
 const (
 	TypeMaster = 0
@@ -8,11 +12,19 @@ const (
 	TypeMulti  = 1 << 2
 )
 
+// NoRescan is the rescan height of the addresses without synchronized blocks to replay
+const NoRescan = math.MaxUint32
+
 type Address struct {
 	Address      string
 	ProgramHash  *Uint168
 	RedeemScript []byte
 	Type         int
+	// The block height from which this address may have transactions
+	Birthday uint32
+	// The next synchronized block to replay for this address, or NoRescan. The address
+	// is left out of the synchronization until the blocks below the wallet height are replayed.
+	RescanHeight uint32
 }
 
 func (addr *Address) TypeName() string {
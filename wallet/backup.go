@@ -0,0 +1,451 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+)
+
+const (
+	BackupMagic   = "ELAWALLETBACKUP"
+	BackupVersion = 1
+)
+
+// Backup is the content of a backup archive, the keystore files and the wallet data
+type Backup struct {
+	Version uint32
+	// If the archive is encrypted with the wallet password
+	Encrypted bool
+	// If the wallet data was encrypted, it's encrypted again when restored
+	DataEncrypted bool
+	// Keystore file contents by file name
+	Keystores map[string][]byte
+
+	data *memoryData
+}
+
+func (backup *Backup) Addresses() []*Address {
+	return backup.data.addresses
+}
+
+func (backup *Backup) Height() uint32 {
+	return backup.data.height
+}
+
+// CreateBackup writes the keystore files of the wallet and the data in store to an archive file.
+// keystore is the keystore file opened by the wallet, it's added when it's not in the wallet directory.
+// The archive is encrypted with a key derived from password unless password is empty,
+// which is refused for encrypted wallet data, so it's never written in plaintext.
+func CreateBackup(store DataStore, keystore string, fileName string, password []byte) (*Backup, error) {
+	if encrypted, ok := store.(*EncryptedDataStore); ok {
+		if encrypted.IsDataLocked() {
+			return nil, ErrDataStoreLocked
+		}
+		if len(password) == 0 {
+			return nil, errors.New("[Wallet], The wallet data is encrypted, the backup archive must be encrypted too")
+		}
+	}
+	backup := &Backup{
+		Version:   BackupVersion,
+		Encrypted: len(password) > 0,
+		Keystores: make(map[string][]byte),
+	}
+	_, backup.DataEncrypted = store.(*EncryptedDataStore)
+
+	files, err := filepath.Glob(filepath.Join(config.WalletDir(), "*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, KeystorePath(keystore))
+	for _, file := range files {
+		content, err := readKeystoreFile(file)
+		if err != nil {
+			continue
+		}
+		backup.Keystores[filepath.Base(file)] = content
+	}
+	if len(backup.Keystores) == 0 {
+		return nil, errors.New("[Wallet], No keystore file found in " + config.WalletDir())
+	}
+
+	backup.data, err = copyMemoryData(store)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := backup.encode(password)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(fileName, content, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// RestoreBackup recreates the wallet in the selected wallet directory from an archive file, password
+// decrypts the archive if it's encrypted and opens the keystores, which must match the wallet addresses.
+func RestoreBackup(fileName string, password []byte) (*Backup, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := decodeBackup(content, password)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := config.WalletDir()
+	for _, file := range config.LegacyWalletFiles {
+		if FileExisted(filepath.Join(dir, file)) {
+			return nil, errors.New("[Wallet], A wallet already exists in " + dir)
+		}
+	}
+	for name := range backup.Keystores {
+		if FileExisted(filepath.Join(dir, name)) {
+			return nil, errors.New("[Wallet], File " + name + " already exists in " + dir)
+		}
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	// None of the wallet files existed, remove them if the wallet can not be restored completely
+	success := false
+	defer func() {
+		if success {
+			return
+		}
+		for name := range backup.Keystores {
+			os.Remove(filepath.Join(dir, name))
+		}
+		for _, file := range config.LegacyWalletFiles {
+			os.Remove(filepath.Join(dir, file))
+		}
+	}()
+
+	for name, keystore := range backup.Keystores {
+		err = ioutil.WriteFile(filepath.Join(dir, name), keystore, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = backup.verifyKeystores(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if backup.DataEncrypted {
+		// The data is never written in plaintext
+		memory := NewMemoryDataStore()
+		err = restoreData(memory, backup.data)
+		if err == nil {
+			store := newEncryptedDataStore(filepath.Join(dir, EncryptedDBName))
+			store.data = memory.data
+			err = store.setPassword(password, nil)
+		}
+	} else {
+		var store DataStore
+		store, err = OpenDataStore()
+		if err == nil {
+			err = restoreData(store, backup.data)
+			store.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	success = true
+	return backup, nil
+}
+
+// verifyKeystores opens the restored keystores with password,
+// every keystore and every master address must have a match.
+func (backup *Backup) verifyKeystores(password []byte) error {
+	matched := make(map[Uint168]bool)
+	for name := range backup.Keystores {
+		keystore, err := OpenKeystore(name, password)
+		if err != nil {
+			return errors.New(fmt.Sprint("[Wallet], Open keystore ", name, " failed, ", err))
+		}
+		programHash := keystore.GetProgramHash()
+		found := false
+		for _, addr := range backup.data.addresses {
+			if addr.ProgramHash.IsEqual(*programHash) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("[Wallet], The key of keystore " + name + " does not match any wallet address")
+		}
+		matched[*programHash] = true
+	}
+	for _, addr := range backup.data.addresses {
+		if addr.Type == TypeMaster && !matched[*addr.ProgramHash] {
+			return errors.New("[Wallet], No keystore matches the master address " + addr.Address)
+		}
+	}
+	return nil
+}
+
+// restoreData adds the wallet data to store, the blocks with stored hashes are committed again,
+// so the next synchronization finds the chain reorganizations below the restored height.
+func restoreData(store DataStore, data *memoryData) error {
+	for _, addr := range data.addresses {
+		err := store.AddAddress(addr.ProgramHash, addr.RedeemScript, addr.Type, addr.Birthday)
+		if err != nil {
+			return err
+		}
+		// The rescan stopped before the backup is resumed by the next synchronization
+		if addr.RescanHeight != NoRescan {
+			err = store.SetRescanHeight(addr.ProgramHash, addr.RescanHeight)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var heights []uint32
+	for height := range data.blocks {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	err := store.BeginBlock()
+	if err != nil {
+		return err
+	}
+	err = restoreUTXOs(store, data)
+	if err != nil {
+		store.AbortBlock()
+		return err
+	}
+	// The changes are recorded at the heights they were made, the ones below the first
+	// stored block are not rolled back and are removed when it's committed
+	if len(heights) == 0 {
+		err = store.CommitRescan()
+	} else {
+		err = store.CommitBlock(heights[0], data.blocks[heights[0]])
+	}
+	if err != nil {
+		return err
+	}
+	for _, height := range heights[1:] {
+		err = store.BeginBlock()
+		if err != nil {
+			return err
+		}
+		err = store.CommitBlock(height, data.blocks[height])
+		if err != nil {
+			return err
+		}
+	}
+
+	for op, record := range data.utxos {
+		if record.Frozen {
+			err = store.SetUTXOFrozen(&op, true)
+			if err != nil {
+				return err
+			}
+		}
+		if record.Label != "" {
+			err = store.SetUTXOLabel(&op, record.Label)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	store.CurrentHeight(data.height)
+	return nil
+}
+
+// restoreUTXOs adds the UTXOs, the spent UTXOs and the transaction history, the spent UTXOs
+// are added and then spent at their heights, so the balances at past heights are kept.
+func restoreUTXOs(store DataStore, data *memoryData) error {
+	for op, record := range data.utxos {
+		err := store.AddAddressUTXO(&record.ProgramHash, record.toUTXO(&op), record.Height)
+		if err != nil {
+			return err
+		}
+	}
+	for op, record := range data.spent {
+		err := store.AddAddressUTXO(&record.ProgramHash, record.toUTXO(&op), record.Height)
+		if err != nil {
+			return err
+		}
+		err = store.DeleteUTXO(&op, record.SpentHeight)
+		if err != nil {
+			return err
+		}
+	}
+	for _, record := range data.histories {
+		err := store.AddTxHistory(&record.ProgramHash, &record.TxHistory)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode writes the header and the content, which is sealed with the key derived from password if it's given
+func (backup *Backup) encode(password []byte) ([]byte, error) {
+	content := new(bytes.Buffer)
+	WriteVarUint(content, uint64(len(backup.Keystores)))
+	for name, keystore := range backup.Keystores {
+		WriteVarString(content, name)
+		WriteVarBytes(content, keystore)
+	}
+	if backup.DataEncrypted {
+		WriteUint8(content, 1)
+	} else {
+		WriteUint8(content, 0)
+	}
+	WriteVarBytes(content, encodeMemoryData(backup.data))
+	defer ClearBytes(content.Bytes())
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(BackupMagic)
+	WriteUint32(buf, backup.Version)
+	if !backup.Encrypted {
+		WriteUint8(buf, 0)
+		buf.Write(content.Bytes())
+		return buf.Bytes(), nil
+	}
+
+	WriteUint8(buf, 1)
+	salt := make([]byte, saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	defer ClearBytes(key)
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	WriteVarBytes(buf, salt)
+	WriteVarBytes(buf, nonce)
+	buf.Write(aead.Seal(nil, nonce, content.Bytes(), nil))
+	return buf.Bytes(), nil
+}
+
+func decodeBackup(value []byte, password []byte) (*Backup, error) {
+	if !bytes.HasPrefix(value, []byte(BackupMagic)) {
+		return nil, errors.New("[Wallet], Not a wallet backup file")
+	}
+	reader := bytes.NewReader(value[len(BackupMagic):])
+	backup := &Backup{Keystores: make(map[string][]byte)}
+	var err error
+	backup.Version, err = ReadUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	if backup.Version != BackupVersion {
+		return nil, errors.New(fmt.Sprint("[Wallet], Unsupported backup version ", backup.Version))
+	}
+	encrypted, err := ReadUint8(reader)
+	if err != nil {
+		return nil, err
+	}
+	backup.Encrypted = encrypted == 1
+
+	content := value[len(value)-reader.Len():]
+	if backup.Encrypted {
+		salt, err := ReadVarBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		nonce, err := ReadVarBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		key, err := deriveKey(password, salt)
+		if err != nil {
+			return nil, err
+		}
+		defer ClearBytes(key)
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		content, err = aead.Open(nil, nonce, value[len(value)-reader.Len():], nil)
+		if err != nil {
+			return nil, errors.New("[Wallet], Wrong password or the backup file is damaged")
+		}
+		defer ClearBytes(content)
+	}
+
+	reader = bytes.NewReader(content)
+	count, err := ReadVarUint(reader, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		name, err := ReadVarString(reader)
+		if err != nil {
+			return nil, err
+		}
+		// Keystores are restored in the wallet directory only
+		if filepath.Base(name) != name {
+			return nil, errors.New("[Wallet], Invalid keystore file name " + name)
+		}
+		keystore, err := ReadVarBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		backup.Keystores[name] = keystore
+	}
+	dataEncrypted, err := ReadUint8(reader)
+	if err != nil {
+		return nil, err
+	}
+	backup.DataEncrypted = dataEncrypted == 1
+	data, err := ReadVarBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+	backup.data, err = decodeMemoryData(data)
+	if err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// readKeystoreFile reads file if it's a keystore of the current version
+func readKeystoreFile(file string) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keystore KeystoreFile
+	err = json.Unmarshal(content, &keystore)
+	if err != nil {
+		return nil, err
+	}
+	if keystore.Version != KeystoreVersion || keystore.PrivateKeyEncrypted == "" {
+		return nil, errors.New("[Wallet], Not a keystore file")
+	}
+	return content, nil
+}
@@ -0,0 +1,42 @@
+package wallet
+
+import (
+	"testing"
+)
+
+func TestRestoreData(t *testing.T) {
+	defer setTestDataDir(t)()
+
+	source := NewMemoryDataStore()
+	mustSucceed(t, source.AddAddress(testProgramHash1, []byte{1}, TypeMaster, 0))
+	mustSucceed(t, source.AddAddress(testProgramHash2, []byte{2}, TypeStand, 7))
+	applyTestBlocks(t, source)
+	data, err := copyMemoryData(source)
+	mustSucceed(t, err)
+
+	for backend, open := range testDataStoreBackends() {
+		t.Run(backend, func(t *testing.T) {
+			store := openTestDataStore(t, "restore-"+backend, open)
+			defer store.Close()
+			mustSucceed(t, restoreData(store, data))
+
+			if store.CurrentHeight(QueryHeightCode) != 2 {
+				t.Fatal("unexpected height", store.CurrentHeight(QueryHeightCode))
+			}
+			hash, err := store.GetStoredBlockHash(1)
+			mustSucceed(t, err)
+			if hash != "hash1" {
+				t.Fatal("unexpected block hash", hash)
+			}
+
+			// The restored block is rolled back as the synchronized one
+			mustSucceed(t, store.RollbackBlock(1))
+			if _, _, err := store.GetUTXO(testOutPoint1); err != nil {
+				t.Fatal("UTXO spent by orphaned block not restored", err)
+			}
+			if _, _, err := store.GetUTXO(testOutPoint2); err != ErrNotFound {
+				t.Fatal("UTXO added by orphaned block found", err)
+			}
+		})
+	}
+}
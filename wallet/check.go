@@ -0,0 +1,224 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/elastos/Elastos.ELA.Client/rpc"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+// Kinds of the discrepancies found by CheckWallet
+const (
+	// A record of an address not in the wallet
+	IssueOrphan = "orphan"
+	// A UTXO recorded both unspent and spent
+	IssueDuplicate = "duplicate"
+	// A stored UTXO which is spent on the node
+	IssueSpent = "spent"
+	// A stored UTXO which is spent on the node, maybe in a block above the synced height
+	IssueUnsyncedSpent = "unsynced-spent"
+	// An unspent output of a wallet address on the node which is not stored
+	IssueMissing = "missing"
+	// A stored balance different from the one on the node
+	IssueBalance = "balance"
+)
+
+// CheckIssue is a discrepancy found by CheckWallet
+type CheckIssue struct {
+	Kind   string
+	Detail string
+}
+
+// CheckBalance is the balance of an address stored in the wallet and the one on the node
+type CheckBalance struct {
+	Address string
+	Stored  Fixed64
+	Node    Fixed64
+}
+
+// CheckReport is the result of CheckWallet
+type CheckReport struct {
+	// The synced height the UTXOs are checked at
+	Height   uint32
+	Balances []*CheckBalance
+	Issues   []*CheckIssue
+	Repaired bool
+}
+
+// CheckWallet checks the records of store by CheckRecords, and the stored UTXOs against the unspent outputs
+// indexed by the node. The stored UTXOs must be unspent on the node, and the unspent outputs of the wallet
+// addresses created until the synced height must be stored. With repair, the inconsistent records are removed
+// and the UTXOs are updated as the node has them, the ones spent on the node are spent at the synced height.
+// The node does not tell the height an output is spent at, so when the wallet is not synced to the chain height,
+// the stored UTXOs spent on the node are reported but not repaired, they may be spent by the blocks not synced yet.
+func CheckWallet(ctx context.Context, store DataStore, repair bool) (*CheckReport, error) {
+	report := &CheckReport{Repaired: repair}
+	issues, err := store.CheckRecords(repair)
+	if err != nil {
+		return nil, err
+	}
+	report.Issues = issues
+
+	currentHeight := store.CurrentHeight(QueryHeightCode)
+	if currentHeight == 0 {
+		// Nothing synced to check
+		return report, nil
+	}
+	report.Height = currentHeight - 1
+
+	var chainHeight uint32
+	err = retry(ctx, func() (err error) {
+		chainHeight, err = GetChainHeight()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := store.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := getUnspentChanges(ctx, store, addresses, chainHeight)
+	if err != nil {
+		return nil, err
+	}
+	// Outputs created after the synced height are not synced yet
+	var added []*addedUTXO
+	for _, a := range changes.added {
+		if a.utxo.Height <= report.Height {
+			added = append(added, a)
+		}
+	}
+	changes.added = added
+	var unsyncedSpent []*OutPoint
+	if chainHeight > report.Height {
+		unsyncedSpent = changes.spent
+		changes.spent = nil
+	}
+
+	balances := make(map[Uint168]*CheckBalance)
+	for _, addr := range addresses {
+		utxos, err := store.GetAddressUTXOs(addr.ProgramHash)
+		if err != nil {
+			return nil, err
+		}
+		balance := &CheckBalance{Address: addr.Address}
+		for _, utxo := range utxos {
+			balance.Stored += *utxo.Amount
+		}
+		balance.Node = balance.Stored
+		balances[*addr.ProgramHash] = balance
+		report.Balances = append(report.Balances, balance)
+	}
+	for _, op := range changes.spent {
+		programHash, utxo, err := store.GetUTXO(op)
+		if err != nil {
+			return nil, err
+		}
+		balance := balances[*programHash]
+		balance.Node -= *utxo.Amount
+		report.Issues = append(report.Issues, &CheckIssue{IssueSpent, fmt.Sprint("UTXO ", FormatOutPoint(op),
+			" of ", balance.Address, " amount ", utxo.Amount.String(), " is spent on the node")})
+	}
+	for _, op := range unsyncedSpent {
+		programHash, utxo, err := store.GetUTXO(op)
+		if err != nil {
+			return nil, err
+		}
+		report.Issues = append(report.Issues, &CheckIssue{IssueUnsyncedSpent, fmt.Sprint("UTXO ", FormatOutPoint(op),
+			" of ", balances[*programHash].Address, " amount ", utxo.Amount.String(),
+			" is spent on the node, maybe above the synced height, sync the wallet to repair it")})
+	}
+	for _, a := range changes.added {
+		balance := balances[*a.programHash]
+		balance.Node += *a.utxo.Amount
+		report.Issues = append(report.Issues, &CheckIssue{IssueMissing, fmt.Sprint("unspent output ",
+			FormatOutPoint(a.utxo.Op), " of ", balance.Address, " amount ", a.utxo.Amount.String(), " is not stored")})
+	}
+	for _, balance := range report.Balances {
+		if balance.Stored != balance.Node {
+			report.Issues = append(report.Issues, &CheckIssue{IssueBalance, fmt.Sprint("stored balance of ", balance.Address,
+				" is ", balance.Stored.String(), ", the node has ", balance.Node.String())})
+		}
+	}
+
+	if !repair || len(changes.spent)+len(changes.added) == 0 {
+		return report, nil
+	}
+	err = store.BeginBlock()
+	if err != nil {
+		return nil, err
+	}
+	err = applyUnspentChanges(store, changes, report.Height)
+	if err != nil {
+		store.AbortBlock()
+		return nil, err
+	}
+	err = store.CommitRescan()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CheckSpentUTXOs returns an error if the UTXOs spent until height are not all recorded, which happens when
+// the data was synchronized by a version not keeping the spent UTXOs, so the balances at past heights are wrong.
+// Every payment in the transaction history spends a UTXO of the paying address at the height of the payment.
+func CheckSpentUTXOs(store DataStore, height uint32) error {
+	addresses, err := store.GetAddresses()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addresses {
+		histories, err := store.GetTxHistory(&HistoryFilter{ProgramHash: addr.ProgramHash})
+		if err != nil {
+			return err
+		}
+		var spentHeights map[uint32]bool
+		for _, history := range histories {
+			if history.Height > height || history.Amount >= 0 {
+				continue
+			}
+			if spentHeights == nil {
+				spent, err := store.GetAddressSpentUTXOs(addr.ProgramHash)
+				if err != nil {
+					return err
+				}
+				spentHeights = make(map[uint32]bool)
+				for _, utxo := range spent {
+					spentHeights[utxo.SpentHeight] = true
+				}
+			}
+			if !spentHeights[history.Height] {
+				return errors.New(fmt.Sprint("[Wallet], The UTXOs spent by transaction ", history.TxID, " at height ",
+					history.Height, " are not recorded, the wallet was synchronized by an earlier version, use --reset to resync"))
+			}
+		}
+	}
+	return nil
+}
+
+func orphanIssue(record string) *CheckIssue {
+	return &CheckIssue{IssueOrphan, record + " belongs to no wallet address"}
+}
+
+func duplicateIssue(op *OutPoint) *CheckIssue {
+	return &CheckIssue{IssueDuplicate, "UTXO " + FormatOutPoint(op) + " is recorded both unspent and spent"}
+}
+
+// FormatOutPoint formats op as txid:index, the form the UTXOs are selected by
+func FormatOutPoint(op *OutPoint) string {
+	return fmt.Sprint(BytesToHexString(op.TxID.Bytes()), ":", op.Index)
+}
+
+func formatOutPointKey(key []byte) string {
+	op, err := decodeOutPoint(key)
+	if err != nil {
+		return BytesToHexString(key)
+	}
+	return FormatOutPoint(op)
+}
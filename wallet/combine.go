@@ -0,0 +1,120 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/elastos/Elastos.ELA.Utility/crypto"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+// CombineTransactions merges the signatures collected in parallel by different cosigners
+// on copies of the same transaction, signatures are placed in the redeem script public keys order.
+func CombineTransactions(txns ...*Transaction) (*Transaction, error) {
+	if len(txns) == 0 {
+		return nil, errors.New("[Wallet], No transaction to combine")
+	}
+	base := txns[0]
+	buf := new(bytes.Buffer)
+	base.SerializeUnsigned(buf)
+	data := buf.Bytes()
+
+	// Check if all transactions are copies of the same one
+	for _, txn := range txns[1:] {
+		buf := new(bytes.Buffer)
+		txn.SerializeUnsigned(buf)
+		if !bytes.Equal(buf.Bytes(), data) {
+			return nil, errors.New("[Wallet], Transactions to combine are not the same")
+		}
+		if len(txn.Programs) != len(base.Programs) {
+			return nil, errors.New("[Wallet], Transactions to combine have different programs")
+		}
+	}
+
+	combined := *base
+	combined.Programs = make([]*Program, 0, len(base.Programs))
+	for i, program := range base.Programs {
+		publicKeys, err := getPublicKeys(program.Code)
+		if err != nil {
+			return nil, err
+		}
+		_, needSign, err := crypto.GetSignStatus(program.Code, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Collect verified signatures by signer index
+		signatures := make([][]byte, len(publicKeys))
+		for _, txn := range txns {
+			if !bytes.Equal(txn.Programs[i].Code, program.Code) {
+				return nil, errors.New(fmt.Sprint("[Wallet], Different redeem script in program ", i))
+			}
+			params, err := splitParameter(txn.Programs[i].Parameter)
+			if err != nil {
+				return nil, err
+			}
+			for _, signature := range params {
+				index, err := matchSignature(publicKeys, data, signature)
+				if err != nil {
+					return nil, errors.New(fmt.Sprint("[Wallet], Invalid signature in program ", i))
+				}
+				signatures[index] = signature
+			}
+		}
+
+		// Rebuild parameter with signatures in signer order
+		param := new(bytes.Buffer)
+		haveSign := 0
+		for _, signature := range signatures {
+			if signature == nil || haveSign == needSign {
+				continue
+			}
+			param.WriteByte(byte(len(signature)))
+			param.Write(signature)
+			haveSign++
+		}
+		combined.Programs = append(combined.Programs, &Program{Code: program.Code, Parameter: param.Bytes()})
+	}
+
+	return &combined, nil
+}
+
+func getPublicKeys(code []byte) ([]*crypto.PublicKey, error) {
+	signType, err := crypto.GetScriptType(code)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys [][]byte
+	switch signType {
+	case STANDARD:
+		keys = [][]byte{code[1 : len(code)-1]}
+	case MULTISIG:
+		keys, err = crypto.ParseMultisigScript(code)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("[Wallet], Unknown redeem script type")
+	}
+
+	var publicKeys []*crypto.PublicKey
+	for _, key := range keys {
+		publicKey, err := crypto.DecodePoint(key)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys, nil
+}
+
+func matchSignature(publicKeys []*crypto.PublicKey, data, signature []byte) (int, error) {
+	for i, publicKey := range publicKeys {
+		if crypto.Verify(*publicKey, data, signature) == nil {
+			return i, nil
+		}
+	}
+	return -1, errors.New("signature not match any public key")
+}
@@ -0,0 +1,47 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCombineTransactions(t *testing.T) {
+	account := newTestMultiSign(t)
+	txn := account.newTestTransaction(9)
+
+	// Cosigners sign their copies in parallel, the signatures are combined in the public keys order
+	signed2, signed0 := account.sign(t, txn, 2), account.sign(t, txn, 0)
+	combined, err := CombineTransactions(signed2, signed0)
+	mustSucceed(t, err)
+	expected := append(append([]byte{}, signed0.Programs[0].Parameter...), signed2.Programs[0].Parameter...)
+	if !bytes.Equal(combined.Programs[0].Parameter, expected) {
+		t.Fatal("signatures are not in the public keys order")
+	}
+	buf := new(bytes.Buffer)
+	mustSucceed(t, combined.SerializeUnsigned(buf))
+	mustSucceed(t, verifySignatures(combined.Programs[0], buf.Bytes()))
+
+	// Signatures more than needed are dropped
+	combined, err = CombineTransactions(account.sign(t, txn, 0, 1), account.sign(t, txn, 2))
+	mustSucceed(t, err)
+	signatures, err := splitParameter(combined.Programs[0].Parameter)
+	mustSucceed(t, err)
+	if len(signatures) != 2 {
+		t.Fatal("unexpected signatures", len(signatures))
+	}
+
+	if _, err := CombineTransactions(); err == nil {
+		t.Fatal("combined no transaction")
+	}
+	other := account.newTestTransaction(8)
+	if _, err := CombineTransactions(account.sign(t, txn, 0), account.sign(t, other, 1)); err == nil {
+		t.Fatal("combined different transactions")
+	}
+	// A signature of the other transaction is not valid for this one
+	forged := account.sign(t, other, 1)
+	forged.Programs[0].Parameter = append(account.sign(t, txn, 0).Programs[0].Parameter, forged.Programs[0].Parameter...)
+	forged.Outputs = txn.Outputs
+	if _, err := CombineTransactions(account.sign(t, txn, 2), forged); err == nil {
+		t.Fatal("combined an invalid signature")
+	}
+}
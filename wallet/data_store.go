@@ -1,50 +1,52 @@
 package wallet
 
 import (
-	"bytes"
-	"database/sql"
+	"errors"
 	"math"
-	"os"
+	"sort"
 	"sync"
 
-	"github.com/elastos/Elastos.ELA.Client/log"
+	"github.com/elastos/Elastos.ELA.Client/config"
 
 	. "github.com/elastos/Elastos.ELA.Utility/common"
 	. "github.com/elastos/Elastos.ELA/core"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	DriverName      = "sqlite3"
-	DBName          = "./wallet.db"
 	QueryHeightCode = 0
 	ResetHeightCode = math.MaxUint32
+	MaxReorgDepth   = 100
 )
 
 const (
-	CreateInfoTable = `CREATE TABLE IF NOT EXISTS Info (
-				Name VARCHAR(20) NOT NULL PRIMARY KEY,
-				Value BLOB
-			);`
-	CreateAddressesTable = `CREATE TABLE IF NOT EXISTS Addresses (
-				Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-				ProgramHash BLOB UNIQUE NOT NULL,
-				RedeemScript BLOB UNIQUE NOT NULL,
-				Type INTEGER NOT NULL
-			);`
-	CreateUTXOsTable = `CREATE TABLE IF NOT EXISTS UTXOs (
-				OutPoint BLOB NOT NULL PRIMARY KEY,
-				Amount BLOB NOT NULL,
-				LockTime INTEGER NOT NULL,
-				AddressId INTEGER NOT NULL,
-				FOREIGN KEY(AddressId) REFERENCES Addresses(Id)
-			);`
+	StorageSQLite = "sqlite"
+	StorageBolt   = "bolt"
+	StorageMemory = "memory"
 )
 
+var ErrNotFound = errors.New("[Wallet], Record not found")
+
+// storages are the data store backends available in this build by name
+var storages = map[string]func() (DataStore, error){
+	StorageBolt:   openBoltDataStore,
+	StorageMemory: openMemoryDataStore,
+}
+
 type UTXO struct {
 	Op       *OutPoint
 	Amount   *Fixed64
 	LockTime uint32
+	// The height of the block this UTXO was created in
+	Height uint32
+	// Frozen UTXO is not spent unless it's selected explicitly
+	Frozen bool
+	Label  string
+}
+
+// SpentUTXO is a UTXO spent by the block at SpentHeight
+type SpentUTXO struct {
+	UTXO
+	SpentHeight uint32
 }
 
 type DataStore interface {
@@ -53,277 +55,153 @@ type DataStore interface {
 
 	CurrentHeight(height uint32) uint32
 
-	AddAddress(programHash *Uint168, redeemScript []byte, addrType int) error
+	AddAddress(programHash *Uint168, redeemScript []byte, addrType int, birthday uint32) error
 	DeleteAddress(programHash *Uint168) error
 	GetAddressInfo(programHash *Uint168) (*Address, error)
 	GetAddresses() ([]*Address, error)
-
-	AddAddressUTXO(programHash *Uint168, utxo *UTXO) error
-	DeleteUTXO(input *OutPoint) error
+	SetRescanHeight(programHash *Uint168, height uint32) error
+
+	// AddAddressUTXO adds the UTXO created at utxo.Height, the change is undone when the block at blockHeight is rolled back
+	AddAddressUTXO(programHash *Uint168, utxo *UTXO, blockHeight uint32) error
+	// DeleteUTXO removes the UTXO spent by the block at height, the change is undone when the block is rolled back
+	DeleteUTXO(input *OutPoint, height uint32) error
+	GetUTXO(op *OutPoint) (*Uint168, *UTXO, error)
+	GetOutPoints() ([]*OutPoint, error)
 	GetAddressUTXOs(programHash *Uint168) ([]*UTXO, error)
+	GetAddressUTXOsAt(programHash *Uint168, height uint32) ([]*UTXO, error)
+	GetAddressSpentUTXOs(programHash *Uint168) ([]*SpentUTXO, error)
+	SetUTXOFrozen(op *OutPoint, frozen bool) error
+	SetUTXOLabel(op *OutPoint, label string) error
+	CheckRecords(repair bool) ([]*CheckIssue, error)
+
+	AddTxHistory(programHash *Uint168, history *TxHistory) error
+	GetTxHistory(filter *HistoryFilter) ([]*TxHistory, error)
+
+	BeginBlock() error
+	CommitBlock(height uint32, hash string) error
+	CommitRescan() error
+	AbortBlock() error
+	GetStoredBlockHash(height uint32) (string, error)
+	RollbackBlock(height uint32) error
 
 	ResetDataStore() error
+	// Close waits for the block being applied and releases the store, it's not usable after that
+	Close() error
 }
 
-type DataStoreImpl struct {
-	sync.Mutex
-	DataSync
-
-	*sql.DB
-}
-
+// OpenDataStore opens the data store backend selected by the Storage config,
+// SQLite is used by default, or bolt when SQLite is not available in this build.
+// The data store of a wallet encrypted by EncryptDataStore is opened locked whatever the config is.
 func OpenDataStore() (DataStore, error) {
-	db, err := initDB()
-	if err != nil {
-		return nil, err
-	}
-	dataStore := &DataStoreImpl{DB: db}
-
-	dataStore.DataSync = GetDataSync(dataStore)
-
-	// Handle system interrupt signals
-	dataStore.catchSystemSignals()
-
-	return dataStore, nil
-}
-
-func initDB() (*sql.DB, error) {
-	db, err := sql.Open(DriverName, DBName)
-	if err != nil {
-		log.Error("Open data db error:", err)
-		return nil, err
-	}
-	// Create info table
-	_, err = db.Exec(CreateInfoTable)
-	if err != nil {
-		return nil, err
-	}
-	// Create addresses table
-	_, err = db.Exec(CreateAddressesTable)
-	if err != nil {
-		return nil, err
-	}
-	// Create UTXOs table
-	_, err = db.Exec(CreateUTXOsTable)
-	if err != nil {
-		return nil, err
+	if EncryptedDataStoreExists() {
+		return openEncryptedDataStore()
+	}
+	storage := config.Params().Storage
+	if storage == "" {
+		storage = StorageSQLite
+		if _, ok := storages[storage]; !ok {
+			storage = StorageBolt
+		}
 	}
-	sql := `INSERT INTO Info(Name, Value) SELECT ?,? WHERE NOT EXISTS(SELECT 1 FROM Info WHERE Name=?)`
-	_, err = db.Exec(sql, "Height", uint32(0), "Height")
-	if err != nil {
-		return nil, err
+	open, ok := storages[storage]
+	if !ok {
+		return nil, errors.New("[Wallet], Storage " + storage + " is not available in this build")
 	}
-
-	return db, nil
+	return open()
 }
 
-func (store *DataStoreImpl) catchSystemSignals() {
-	HandleSignal(func() {
-		store.Lock()
-		store.Close()
-		os.Exit(-1)
+// filterTxHistory selects the records matching filter in the order of height and transaction ID,
+// it is used by the backends which can not query the records in place.
+func filterTxHistory(histories []*historyRecord, filter *HistoryFilter) ([]*TxHistory, error) {
+	var selected []*historyRecord
+	for _, h := range histories {
+		if filter.ProgramHash != nil && !h.ProgramHash.IsEqual(*filter.ProgramHash) {
+			continue
+		}
+		if filter.FromHeight > 0 && h.Height < filter.FromHeight {
+			continue
+		}
+		if filter.ToHeight > 0 && h.Height > filter.ToHeight {
+			continue
+		}
+		if filter.FromTime > 0 && h.Time < filter.FromTime {
+			continue
+		}
+		if filter.ToTime > 0 && h.Time > filter.ToTime {
+			continue
+		}
+		selected = append(selected, h)
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		if selected[i].Height != selected[j].Height {
+			return selected[i].Height < selected[j].Height
+		}
+		return selected[i].TxID < selected[j].TxID
 	})
-}
-
-func (store *DataStoreImpl) ResetDataStore() error {
 
-	_, err := store.Exec(`DROP TABLE IF EXISTS Info;
-								DROP TABLE IF EXISTS UTXOs;`)
-	if err != nil {
-		return err
+	if filter.Limit > 0 {
+		if filter.Offset >= len(selected) {
+			return nil, nil
+		}
+		selected = selected[filter.Offset:]
+		if filter.Limit < len(selected) {
+			selected = selected[:filter.Limit]
+		}
 	}
 
-	return nil
-}
-
-func (store *DataStoreImpl) CurrentHeight(height uint32) uint32 {
-	store.Lock()
-	defer store.Unlock()
-
-	row := store.QueryRow("SELECT Value FROM Info WHERE Name=?", "Height")
-	var storedHeight uint32
-	row.Scan(&storedHeight)
-
-	if height > storedHeight {
-		// Received reset height code
-		if height == ResetHeightCode {
-			height = 0
-		}
-		// Insert current height
-		_, err := store.Exec("UPDATE Info SET Value=? WHERE Name=?", height, "Height")
+	var result []*TxHistory
+	for _, h := range selected {
+		address, err := h.ProgramHash.ToAddress()
 		if err != nil {
-			return uint32(0)
+			return nil, err
 		}
-		return height
+		history := h.TxHistory
+		history.Address = address
+		result = append(result, &history)
 	}
-	return storedHeight
+	return result, nil
 }
 
-func (store *DataStoreImpl) AddAddress(programHash *Uint168, redeemScript []byte, addrType int) error {
-	store.Lock()
-	defer store.Unlock()
-
-	sql := "INSERT INTO Addresses(ProgramHash, RedeemScript, Type) values(?,?,?)"
-	_, err := store.Exec(sql, programHash.Bytes(), redeemScript, addrType)
-	if err != nil {
-		return err
-	}
-	return nil
+// utxoRecord is a UTXO saved by the backends other than SQLite
+type utxoRecord struct {
+	ProgramHash Uint168
+	Amount      Fixed64
+	LockTime    uint32
+	Height      uint32
+	Frozen      bool
+	Label       string
 }
 
-func (store *DataStoreImpl) DeleteAddress(programHash *Uint168) error {
-	store.Lock()
-	defer store.Unlock()
-
-	// Find addressId by ProgramHash
-	row := store.QueryRow("SELECT Id FROM Addresses WHERE ProgramHash=?", programHash.Bytes())
-	var addressId int
-	err := row.Scan(&addressId)
-	if err != nil {
-		return err
-	}
-
-	// Delete UTXOs of this address
-	_, err = store.Exec("DELETE FROM UTXOs WHERE AddressId=?", addressId)
-	if err != nil {
-		return err
-	}
-
-	// Delete address from address table
-	_, err = store.Exec("DELETE FROM Addresses WHERE Id=?", addressId)
-	if err != nil {
-		return err
-	}
-	return nil
+func (record *utxoRecord) toUTXO(op *OutPoint) *UTXO {
+	amount := record.Amount
+	return &UTXO{NewOutPoint(op.TxID, op.Index), &amount, record.LockTime, record.Height, record.Frozen, record.Label}
 }
 
-func (store *DataStoreImpl) GetAddressInfo(programHash *Uint168) (*Address, error) {
-	store.Lock()
-	defer store.Unlock()
-
-	// Query address info by it's ProgramHash
-	row := store.QueryRow(`SELECT RedeemScript, Type FROM Addresses WHERE ProgramHash=?`, programHash.Bytes())
-	var redeemScript []byte
-	var addrType int
-	err := row.Scan(&redeemScript, &addrType)
-	if err != nil {
-		return nil, err
-	}
-	address, err := programHash.ToAddress()
-	if err != nil {
-		return nil, err
-	}
-	return &Address{address, programHash, redeemScript, addrType}, nil
+// spentRecord is a spent UTXO kept for the balance at past heights
+type spentRecord struct {
+	utxoRecord
+	SpentHeight uint32
 }
 
-func (store *DataStoreImpl) GetAddresses() ([]*Address, error) {
-	store.Lock()
-	defer store.Unlock()
-
-	rows, err := store.Query("SELECT ProgramHash, RedeemScript, Type FROM Addresses")
-	if err != nil {
-		log.Error("Get address query error:", err)
-		return nil, err
-	}
-	defer rows.Close()
-
-	var addresses []*Address
-	for rows.Next() {
-		var programHashBytes []byte
-		var redeemScript []byte
-		var addrType int
-		err = rows.Scan(&programHashBytes, &redeemScript, &addrType)
-		if err != nil {
-			log.Error("Get address scan row:", err)
-			return nil, err
-		}
-		programHash, err := Uint168FromBytes(programHashBytes)
-		if err != nil {
-			return nil, err
-		}
-		address, err := programHash.ToAddress()
-		if err != nil {
-			return nil, err
-		}
-		addresses = append(addresses, &Address{address, programHash, redeemScript, addrType})
-	}
-	return addresses, nil
+// unspentAt returns if the UTXO existed after the block at height was applied
+func (record *spentRecord) unspentAt(height uint32) bool {
+	return record.Height <= height && record.SpentHeight > height
 }
 
-func (store *DataStoreImpl) AddAddressUTXO(programHash *Uint168, utxo *UTXO) error {
-	store.Lock()
-	defer store.Unlock()
-
-	// Find addressId by ProgramHash
-	row := store.QueryRow("SELECT Id FROM Addresses WHERE ProgramHash=?", programHash.Bytes())
-	var addressId int
-	err := row.Scan(&addressId)
-	if err != nil {
-		return err
-	}
-	// Serialize input
-	buf := new(bytes.Buffer)
-	utxo.Op.Serialize(buf)
-	opBytes := buf.Bytes()
-	// Serialize amount
-	buf = new(bytes.Buffer)
-	utxo.Amount.Serialize(buf)
-	amountBytes := buf.Bytes()
-	// Do insert
-	sql := "INSERT INTO UTXOs(OutPoint, Amount, LockTime, AddressId) values(?,?,?,?)"
-	_, err = store.Exec(sql, opBytes, amountBytes, utxo.LockTime, addressId)
-	if err != nil {
-		return err
-	}
-	return nil
+func (record *spentRecord) toSpentUTXO(op *OutPoint) *SpentUTXO {
+	return &SpentUTXO{*record.toUTXO(op), record.SpentHeight}
 }
 
-func (store *DataStoreImpl) DeleteUTXO(op *OutPoint) error {
-	store.Lock()
-	defer store.Unlock()
-
-	// Serialize input
-	buf := new(bytes.Buffer)
-	op.Serialize(buf)
-	opBytes := buf.Bytes()
-	// Do delete
-	_, err := store.Exec("DELETE FROM UTXOs WHERE OutPoint=?", opBytes)
-	if err != nil {
-		return err
-	}
-	return nil
+// undoRecord is the change of a UTXO made by the block at height
+type undoRecord struct {
+	Height uint32
+	Op     OutPoint
+	utxoRecord
+	Spent bool
 }
 
-func (store *DataStoreImpl) GetAddressUTXOs(programHash *Uint168) ([]*UTXO, error) {
-	store.Lock()
-	defer store.Unlock()
-
-	rows, err := store.Query(`SELECT UTXOs.OutPoint, UTXOs.Amount, UTXOs.LockTime FROM UTXOs INNER JOIN Addresses
- 								ON UTXOs.AddressId=Addresses.Id WHERE Addresses.ProgramHash=?`, programHash.Bytes())
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var inputs []*UTXO
-	for rows.Next() {
-		var opBytes []byte
-		var amountBytes []byte
-		var lockTime uint32
-		err = rows.Scan(&opBytes, &amountBytes, &lockTime)
-		if err != nil {
-			return nil, err
-		}
-
-		var op OutPoint
-		reader := bytes.NewReader(opBytes)
-		op.Deserialize(reader)
-
-		var amount Fixed64
-		reader = bytes.NewReader(amountBytes)
-		amount.Deserialize(reader)
-
-		inputs = append(inputs, &UTXO{&op, &amount, lockTime})
-	}
-	return inputs, nil
+// historyRecord is a transaction history record with it's owner
+type historyRecord struct {
+	ProgramHash Uint168
+	TxHistory
 }
@@ -0,0 +1,1108 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	BoltDBName        = "wallet.bolt"
+	BoltSchemaVersion = 1
+	BoltOpenTimeout   = time.Second
+)
+
+var (
+	boltInfoBucket         = []byte("Info")
+	boltAddressesBucket    = []byte("Addresses")
+	boltUTXOsBucket        = []byte("UTXOs")
+	boltSpentUTXOsBucket   = []byte("SpentUTXOs")
+	boltBlocksBucket       = []byte("Blocks")
+	boltUndoLogBucket      = []byte("UndoLog")
+	boltTransactionsBucket = []byte("Transactions")
+
+	boltHeightKey        = []byte("Height")
+	boltSchemaVersionKey = []byte("SchemaVersion")
+)
+
+// BoltDataStore is the data store saved in a bolt key value database, it's written in pure Go.
+type BoltDataStore struct {
+	sync.Mutex
+	DataSync
+
+	db *bolt.DB
+	// The database transaction of the block being applied
+	tx *bolt.Tx
+	// Held while a block is being applied or rolled back
+	blockMutex sync.Mutex
+}
+
+func openBoltDataStore() (DataStore, error) {
+	err := os.MkdirAll(config.WalletDir(), 0700)
+	if err != nil {
+		return nil, err
+	}
+	fileName := filepath.Join(config.WalletDir(), BoltDBName)
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: BoltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(initBoltDB)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store := &BoltDataStore{db: db}
+
+	store.DataSync = GetDataSync(store)
+
+	return store, nil
+}
+
+func initBoltDB(tx *bolt.Tx) error {
+	for _, name := range [][]byte{boltInfoBucket, boltAddressesBucket, boltUTXOsBucket, boltSpentUTXOsBucket,
+		boltBlocksBucket, boltUndoLogBucket, boltTransactionsBucket} {
+		_, err := tx.CreateBucketIfNotExists(name)
+		if err != nil {
+			return err
+		}
+	}
+	info := tx.Bucket(boltInfoBucket)
+	// A later version of the records must be upgraded here from the versions before it
+	if value := info.Get(boltSchemaVersionKey); value != nil {
+		version := decodeUint32(value)
+		if version > BoltSchemaVersion {
+			return errors.New(fmt.Sprint("[Wallet], Database version ", version,
+				" is newer than supported version ", BoltSchemaVersion, ", please upgrade the client"))
+		}
+	}
+	err := info.Put(boltSchemaVersionKey, encodeUint32(BoltSchemaVersion))
+	if err != nil {
+		return err
+	}
+	if info.Get(boltHeightKey) == nil {
+		return info.Put(boltHeightKey, encodeUint32(0))
+	}
+	return nil
+}
+
+// Close waits for the block being applied and closes the database
+func (store *BoltDataStore) Close() error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	return store.db.Close()
+}
+
+// update runs fn in the transaction of the block being applied, or in a new one
+func (store *BoltDataStore) update(fn func(tx *bolt.Tx) error) error {
+	if store.tx != nil {
+		return fn(store.tx)
+	}
+	return store.db.Update(fn)
+}
+
+// view runs fn in the transaction of the block being applied, or in a new read only one
+func (store *BoltDataStore) view(fn func(tx *bolt.Tx) error) error {
+	if store.tx != nil {
+		return fn(store.tx)
+	}
+	return store.db.View(fn)
+}
+
+func (store *BoltDataStore) ResetDataStore() error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltUTXOsBucket, boltSpentUTXOsBucket, boltBlocksBucket, boltUndoLogBucket,
+			boltTransactionsBucket} {
+			err := tx.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltInfoBucket).Put(boltHeightKey, encodeUint32(0))
+	})
+}
+
+func (store *BoltDataStore) CurrentHeight(height uint32) uint32 {
+	store.Lock()
+	defer store.Unlock()
+
+	var storedHeight uint32
+	store.view(func(tx *bolt.Tx) error {
+		storedHeight = decodeUint32(tx.Bucket(boltInfoBucket).Get(boltHeightKey))
+		return nil
+	})
+
+	if height > storedHeight {
+		// Received reset height code
+		if height == ResetHeightCode {
+			height = 0
+		}
+		err := store.update(func(tx *bolt.Tx) error {
+			return tx.Bucket(boltInfoBucket).Put(boltHeightKey, encodeUint32(height))
+		})
+		if err != nil {
+			return uint32(0)
+		}
+		return height
+	}
+	return storedHeight
+}
+
+func (store *BoltDataStore) AddAddress(programHash *Uint168, redeemScript []byte, addrType int, birthday uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAddressesBucket)
+		if bucket.Get(programHash.Bytes()) != nil {
+			return errors.New("[Wallet], Address already exists")
+		}
+		// Sequence keeps the addresses in the order they were added
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(programHash.Bytes(), encodeAddress(id, redeemScript, addrType, birthday, NoRescan))
+	})
+}
+
+func (store *BoltDataStore) SetRescanHeight(programHash *Uint168, height uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.update(func(tx *bolt.Tx) error {
+		addresses := tx.Bucket(boltAddressesBucket)
+		value := addresses.Get(programHash.Bytes())
+		if value == nil {
+			return ErrNotFound
+		}
+		id, addr, err := decodeAddress(programHash.Bytes(), value)
+		if err != nil {
+			return err
+		}
+		return addresses.Put(programHash.Bytes(), encodeAddress(id, addr.RedeemScript, addr.Type, addr.Birthday, height))
+	})
+}
+
+func (store *BoltDataStore) DeleteAddress(programHash *Uint168) error {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.update(func(tx *bolt.Tx) error {
+		addresses := tx.Bucket(boltAddressesBucket)
+		if addresses.Get(programHash.Bytes()) == nil {
+			return ErrNotFound
+		}
+
+		// Delete UTXOs of this address
+		err := deleteRecords(tx.Bucket(boltUTXOsBucket), func(k, v []byte) bool {
+			return bytes.HasPrefix(v, programHash.Bytes())
+		})
+		if err != nil {
+			return err
+		}
+
+		// Delete spent UTXOs of this address
+		err = deleteRecords(tx.Bucket(boltSpentUTXOsBucket), func(k, v []byte) bool {
+			return bytes.HasPrefix(v, programHash.Bytes())
+		})
+		if err != nil {
+			return err
+		}
+
+		// Delete undo records of this address
+		err = deleteRecords(tx.Bucket(boltUndoLogBucket), func(k, v []byte) bool {
+			undo, err := decodeUndoRecord(v)
+			return err == nil && undo.ProgramHash.IsEqual(*programHash)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Delete transaction history of this address
+		err = deleteRecords(tx.Bucket(boltTransactionsBucket), func(k, v []byte) bool {
+			return bytes.HasSuffix(k, programHash.Bytes())
+		})
+		if err != nil {
+			return err
+		}
+
+		// Delete address from addresses bucket
+		return addresses.Delete(programHash.Bytes())
+	})
+}
+
+func (store *BoltDataStore) GetAddressInfo(programHash *Uint168) (*Address, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var address *Address
+	err := store.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltAddressesBucket).Get(programHash.Bytes())
+		if value == nil {
+			return ErrNotFound
+		}
+		var err error
+		_, address, err = decodeAddress(programHash.Bytes(), value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+func (store *BoltDataStore) GetAddresses() ([]*Address, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	ids := make(map[*Address]uint64)
+	var addresses []*Address
+	err := store.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAddressesBucket).ForEach(func(k, v []byte) error {
+			id, address, err := decodeAddress(k, v)
+			if err != nil {
+				return err
+			}
+			ids[address] = id
+			addresses = append(addresses, address)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return ids[addresses[i]] < ids[addresses[j]]
+	})
+	return addresses, nil
+}
+
+func (store *BoltDataStore) AddAddressUTXO(programHash *Uint168, utxo *UTXO, blockHeight uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltAddressesBucket).Get(programHash.Bytes()) == nil {
+			return ErrNotFound
+		}
+		utxos := tx.Bucket(boltUTXOsBucket)
+		key := encodeOutPoint(utxo.Op)
+		if utxos.Get(key) != nil {
+			return errors.New("[Wallet], UTXO already exists")
+		}
+		record := &utxoRecord{*programHash, *utxo.Amount, utxo.LockTime, utxo.Height, false, ""}
+		err := utxos.Put(key, encodeUTXORecord(record))
+		if err != nil {
+			return err
+		}
+		// Record undo information in case the block was orphaned
+		return putUndoRecord(tx, &undoRecord{blockHeight, *utxo.Op, *record, false})
+	})
+}
+
+func (store *BoltDataStore) DeleteUTXO(op *OutPoint, height uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.update(func(tx *bolt.Tx) error {
+		utxos := tx.Bucket(boltUTXOsBucket)
+		key := encodeOutPoint(op)
+		// Skip if it's not in this wallet
+		value := utxos.Get(key)
+		if value == nil {
+			return nil
+		}
+		record, err := decodeUTXORecord(value)
+		if err != nil {
+			return err
+		}
+		err = utxos.Delete(key)
+		if err != nil {
+			return err
+		}
+		// Record undo information in case the block was orphaned
+		err = putUndoRecord(tx, &undoRecord{height, *op, *record, true})
+		if err != nil {
+			return err
+		}
+		// Keep the spent UTXO for the balance at past heights
+		return tx.Bucket(boltSpentUTXOsBucket).Put(key, encodeSpentRecord(&spentRecord{*record, height}))
+	})
+}
+
+func (store *BoltDataStore) GetUTXO(op *OutPoint) (*Uint168, *UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var record *utxoRecord
+	err := store.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltUTXOsBucket).Get(encodeOutPoint(op))
+		if value == nil {
+			return ErrNotFound
+		}
+		var err error
+		record, err = decodeUTXORecord(value)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &record.ProgramHash, record.toUTXO(op), nil
+}
+
+// GetAddressUTXOsAt returns the UTXOs of the address after the block at height was applied
+func (store *BoltDataStore) GetAddressUTXOsAt(programHash *Uint168, height uint32) ([]*UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var utxos []*UTXO
+	err := store.view(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltUTXOsBucket).ForEach(func(k, v []byte) error {
+			if !bytes.HasPrefix(v, programHash.Bytes()) {
+				return nil
+			}
+			op, err := decodeOutPoint(k)
+			if err != nil {
+				return err
+			}
+			record, err := decodeUTXORecord(v)
+			if err != nil {
+				return err
+			}
+			if record.Height <= height {
+				utxos = append(utxos, record.toUTXO(op))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltSpentUTXOsBucket).ForEach(func(k, v []byte) error {
+			if !bytes.HasPrefix(v, programHash.Bytes()) {
+				return nil
+			}
+			op, err := decodeOutPoint(k)
+			if err != nil {
+				return err
+			}
+			record, err := decodeSpentRecord(v)
+			if err != nil {
+				return err
+			}
+			if record.unspentAt(height) {
+				utxos = append(utxos, record.toUTXO(op))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+func (store *BoltDataStore) GetAddressSpentUTXOs(programHash *Uint168) ([]*SpentUTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var utxos []*SpentUTXO
+	err := store.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSpentUTXOsBucket).ForEach(func(k, v []byte) error {
+			if !bytes.HasPrefix(v, programHash.Bytes()) {
+				return nil
+			}
+			op, err := decodeOutPoint(k)
+			if err != nil {
+				return err
+			}
+			record, err := decodeSpentRecord(v)
+			if err != nil {
+				return err
+			}
+			utxos = append(utxos, record.toSpentUTXO(op))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+func (store *BoltDataStore) SetUTXOFrozen(op *OutPoint, frozen bool) error {
+	return store.updateUTXO(op, func(record *utxoRecord) { record.Frozen = frozen })
+}
+
+func (store *BoltDataStore) SetUTXOLabel(op *OutPoint, label string) error {
+	return store.updateUTXO(op, func(record *utxoRecord) { record.Label = label })
+}
+
+// updateUTXO saves the UTXO record of op changed by update
+func (store *BoltDataStore) updateUTXO(op *OutPoint, update func(record *utxoRecord)) error {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.update(func(tx *bolt.Tx) error {
+		utxos := tx.Bucket(boltUTXOsBucket)
+		key := encodeOutPoint(op)
+		value := utxos.Get(key)
+		if value == nil {
+			return ErrNotFound
+		}
+		record, err := decodeUTXORecord(value)
+		if err != nil {
+			return err
+		}
+		update(record)
+		return utxos.Put(key, encodeUTXORecord(record))
+	})
+}
+
+func (store *BoltDataStore) GetOutPoints() ([]*OutPoint, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var ops []*OutPoint
+	err := store.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUTXOsBucket).ForEach(func(k, v []byte) error {
+			op, err := decodeOutPoint(k)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, op)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func (store *BoltDataStore) GetAddressUTXOs(programHash *Uint168) ([]*UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var utxos []*UTXO
+	err := store.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUTXOsBucket).ForEach(func(k, v []byte) error {
+			if !bytes.HasPrefix(v, programHash.Bytes()) {
+				return nil
+			}
+			op, err := decodeOutPoint(k)
+			if err != nil {
+				return err
+			}
+			record, err := decodeUTXORecord(v)
+			if err != nil {
+				return err
+			}
+			utxos = append(utxos, record.toUTXO(op))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+// CheckRecords finds the records of addresses not in the wallet and the UTXOs both unspent and spent
+func (store *BoltDataStore) CheckRecords(repair bool) ([]*CheckIssue, error) {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	var issues []*CheckIssue
+	check := func(tx *bolt.Tx) error {
+		addresses := tx.Bucket(boltAddressesBucket)
+		utxos := tx.Bucket(boltUTXOsBucket)
+		isOrphan := func(programHash []byte) bool {
+			return len(programHash) < len(Uint168{}) || addresses.Get(programHash[:len(Uint168{})]) == nil
+		}
+		checks := []struct {
+			bucket []byte
+			match  func(k, v []byte) *CheckIssue
+		}{
+			{boltUTXOsBucket, func(k, v []byte) *CheckIssue {
+				if isOrphan(v) {
+					return orphanIssue("UTXO " + formatOutPointKey(k))
+				}
+				return nil
+			}},
+			{boltSpentUTXOsBucket, func(k, v []byte) *CheckIssue {
+				if isOrphan(v) {
+					return orphanIssue("spent UTXO " + formatOutPointKey(k))
+				}
+				if utxos.Get(k) != nil {
+					op, err := decodeOutPoint(k)
+					if err == nil {
+						return duplicateIssue(op)
+					}
+				}
+				return nil
+			}},
+			{boltUndoLogBucket, func(k, v []byte) *CheckIssue {
+				undo, err := decodeUndoRecord(v)
+				if err == nil && isOrphan(undo.ProgramHash.Bytes()) {
+					return orphanIssue("undo record of UTXO " + FormatOutPoint(&undo.Op))
+				}
+				return nil
+			}},
+			{boltTransactionsBucket, func(k, v []byte) *CheckIssue {
+				record, err := decodeHistoryRecord(k, v)
+				if err == nil && isOrphan(record.ProgramHash.Bytes()) {
+					return orphanIssue("transaction " + record.TxID)
+				}
+				return nil
+			}},
+		}
+		for _, c := range checks {
+			match := c.match
+			found := func(k, v []byte) bool {
+				issue := match(k, v)
+				if issue != nil {
+					issues = append(issues, issue)
+				}
+				return issue != nil
+			}
+			var err error
+			if repair {
+				err = deleteRecords(tx.Bucket(c.bucket), found)
+			} else {
+				err = tx.Bucket(c.bucket).ForEach(func(k, v []byte) error {
+					found(k, v)
+					return nil
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if repair {
+		err = store.db.Update(check)
+	} else {
+		err = store.db.View(check)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return issues, nil
+}
+
+func (store *BoltDataStore) AddTxHistory(programHash *Uint168, history *TxHistory) error {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltAddressesBucket).Get(programHash.Bytes()) == nil {
+			return ErrNotFound
+		}
+		// Replace the record when the block is synced again
+		key := append([]byte(history.TxID), programHash.Bytes()...)
+		return tx.Bucket(boltTransactionsBucket).Put(key, encodeTxHistory(history))
+	})
+}
+
+func (store *BoltDataStore) GetTxHistory(filter *HistoryFilter) ([]*TxHistory, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var histories []*historyRecord
+	err := store.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTransactionsBucket).ForEach(func(k, v []byte) error {
+			record, err := decodeHistoryRecord(k, v)
+			if err != nil {
+				return err
+			}
+			histories = append(histories, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filterTxHistory(histories, filter)
+}
+
+// BeginBlock starts a database transaction, the changes made until CommitBlock
+// are applied atomically along with the block hash and the wallet height.
+func (store *BoltDataStore) BeginBlock() error {
+	store.blockMutex.Lock()
+	store.Lock()
+	defer store.Unlock()
+
+	tx, err := store.db.Begin(true)
+	if err != nil {
+		store.blockMutex.Unlock()
+		return err
+	}
+	store.tx = tx
+	return nil
+}
+
+func (store *BoltDataStore) CommitBlock(height uint32, hash string) error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	tx := store.tx
+	store.tx = nil
+
+	err := saveBoltBlockHash(tx, height, hash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Update wallet height
+	err = tx.Bucket(boltInfoBucket).Put(boltHeightKey, encodeUint32(height+1))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// CommitRescan commits the changes of a rescanned block,
+// the block hash and wallet height are left untouched.
+func (store *BoltDataStore) CommitRescan() error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	tx := store.tx
+	store.tx = nil
+	return tx.Commit()
+}
+
+func (store *BoltDataStore) AbortBlock() error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	tx := store.tx
+	store.tx = nil
+	return tx.Rollback()
+}
+
+func saveBoltBlockHash(tx *bolt.Tx, height uint32, hash string) error {
+	blocks := tx.Bucket(boltBlocksBucket)
+	err := blocks.Put(encodeUint32(height), []byte(hash))
+	if err != nil {
+		return err
+	}
+	// Blocks deeper than max reorg depth will not be rolled back, remove them
+	if height > MaxReorgDepth {
+		bound := encodeUint32(height - MaxReorgDepth)
+		err = deleteRecords(blocks, func(k, v []byte) bool {
+			return bytes.Compare(k, bound) < 0
+		})
+		if err != nil {
+			return err
+		}
+		err = deleteRecords(tx.Bucket(boltUndoLogBucket), func(k, v []byte) bool {
+			return bytes.Compare(k[:4], bound) < 0
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *BoltDataStore) GetStoredBlockHash(height uint32) (string, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var hash string
+	err := store.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBlocksBucket).Get(encodeUint32(height))
+		if value == nil {
+			return ErrNotFound
+		}
+		hash = string(value)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func (store *BoltDataStore) RollbackBlock(height uint32) error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		// Undo records of the block are keyed by height and sequence
+		var undos []*undoRecord
+		prefix := encodeUint32(height)
+		cursor := tx.Bucket(boltUndoLogBucket).Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			undo, err := decodeUndoRecord(v)
+			if err != nil {
+				return err
+			}
+			undos = append(undos, undo)
+		}
+
+		// Undo records are reverted in the reverse order they were made
+		utxos := tx.Bucket(boltUTXOsBucket)
+		for i := len(undos) - 1; i >= 0; i-- {
+			undo := undos[i]
+			key := encodeOutPoint(&undo.Op)
+			var err error
+			if undo.Spent {
+				// Restore the UTXO spent in orphaned block
+				if utxos.Get(key) == nil {
+					err = utxos.Put(key, encodeUTXORecord(&undo.utxoRecord))
+				}
+			} else {
+				// Remove the UTXO added in orphaned block
+				err = utxos.Delete(key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// The UTXOs spent in orphaned blocks are unspent again
+		err := deleteRecords(tx.Bucket(boltSpentUTXOsBucket), func(k, v []byte) bool {
+			record, err := decodeSpentRecord(v)
+			return err == nil && record.SpentHeight >= height
+		})
+		if err != nil {
+			return err
+		}
+		err = deleteRecords(tx.Bucket(boltUndoLogBucket), func(k, v []byte) bool {
+			return bytes.Compare(k[:4], prefix) >= 0
+		})
+		if err != nil {
+			return err
+		}
+		err = deleteRecords(tx.Bucket(boltBlocksBucket), func(k, v []byte) bool {
+			return bytes.Compare(k, prefix) >= 0
+		})
+		if err != nil {
+			return err
+		}
+		err = deleteRecords(tx.Bucket(boltTransactionsBucket), func(k, v []byte) bool {
+			record, err := decodeHistoryRecord(k, v)
+			return err == nil && record.Height >= height
+		})
+		if err != nil {
+			return err
+		}
+		// Set wallet height back to the orphaned block
+		return tx.Bucket(boltInfoBucket).Put(boltHeightKey, encodeUint32(height))
+	})
+}
+
+// deleteRecords deletes the records in bucket matching the given condition
+func deleteRecords(bucket *bolt.Bucket, match func(k, v []byte) bool) error {
+	var keys [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if match(k, v) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		err = bucket.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putUndoRecord(tx *bolt.Tx, undo *undoRecord) error {
+	bucket := tx.Bucket(boltUndoLogBucket)
+	sequence, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint32(key, undo.Height)
+	binary.BigEndian.PutUint64(key[4:], sequence)
+	return bucket.Put(key, encodeUndoRecord(undo))
+}
+
+func encodeUndoRecord(undo *undoRecord) []byte {
+	buf := new(bytes.Buffer)
+	WriteUint32(buf, undo.Height)
+	undo.Op.Serialize(buf)
+	buf.Write(encodeUTXORecord(&undo.utxoRecord))
+	if undo.Spent {
+		WriteUint8(buf, 1)
+	} else {
+		WriteUint8(buf, 0)
+	}
+	return buf.Bytes()
+}
+
+func decodeUndoRecord(value []byte) (*undoRecord, error) {
+	var undo undoRecord
+	reader := bytes.NewReader(value)
+	var err error
+	undo.Height, err = ReadUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	err = undo.Op.Deserialize(reader)
+	if err != nil {
+		return nil, err
+	}
+	err = readUTXORecord(reader, &undo.utxoRecord)
+	if err != nil {
+		return nil, err
+	}
+	spent, err := ReadUint8(reader)
+	if err != nil {
+		return nil, err
+	}
+	undo.Spent = spent == 1
+	return &undo, nil
+}
+
+// encodeUTXORecord puts the program hash first, so the records can be matched by address prefix
+func encodeUTXORecord(record *utxoRecord) []byte {
+	buf := new(bytes.Buffer)
+	record.ProgramHash.Serialize(buf)
+	record.Amount.Serialize(buf)
+	WriteUint32(buf, record.LockTime)
+	WriteUint32(buf, record.Height)
+	if record.Frozen {
+		WriteUint8(buf, 1)
+	} else {
+		WriteUint8(buf, 0)
+	}
+	WriteVarString(buf, record.Label)
+	return buf.Bytes()
+}
+
+func decodeUTXORecord(value []byte) (*utxoRecord, error) {
+	var record utxoRecord
+	err := readUTXORecord(bytes.NewReader(value), &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func readUTXORecord(reader *bytes.Reader, record *utxoRecord) error {
+	err := record.ProgramHash.Deserialize(reader)
+	if err != nil {
+		return err
+	}
+	err = record.Amount.Deserialize(reader)
+	if err != nil {
+		return err
+	}
+	record.LockTime, err = ReadUint32(reader)
+	if err != nil {
+		return err
+	}
+	record.Height, err = ReadUint32(reader)
+	if err != nil {
+		return err
+	}
+	frozen, err := ReadUint8(reader)
+	if err != nil {
+		return err
+	}
+	record.Frozen = frozen == 1
+	record.Label, err = ReadVarString(reader)
+	return err
+}
+
+// encodeSpentRecord puts the UTXO record first, so the records can be matched by address prefix
+func encodeSpentRecord(record *spentRecord) []byte {
+	buf := bytes.NewBuffer(encodeUTXORecord(&record.utxoRecord))
+	WriteUint32(buf, record.SpentHeight)
+	return buf.Bytes()
+}
+
+func decodeSpentRecord(value []byte) (*spentRecord, error) {
+	var record spentRecord
+	reader := bytes.NewReader(value)
+	err := readUTXORecord(reader, &record.utxoRecord)
+	if err != nil {
+		return nil, err
+	}
+	record.SpentHeight, err = ReadUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// encodeAddress encodes the address value, the id keeps the addresses in the order they were added
+func encodeAddress(id uint64, redeemScript []byte, addrType int, birthday, rescanHeight uint32) []byte {
+	buf := new(bytes.Buffer)
+	WriteUint64(buf, id)
+	WriteVarBytes(buf, redeemScript)
+	WriteUint32(buf, uint32(addrType))
+	WriteUint32(buf, birthday)
+	WriteUint32(buf, rescanHeight)
+	return buf.Bytes()
+}
+
+func decodeAddress(key, value []byte) (uint64, *Address, error) {
+	programHash, err := Uint168FromBytes(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	address, err := programHash.ToAddress()
+	if err != nil {
+		return 0, nil, err
+	}
+	reader := bytes.NewReader(value)
+	id, err := ReadUint64(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	redeemScript, err := ReadVarBytes(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	addrType, err := ReadUint32(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	birthday, err := ReadUint32(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	rescanHeight, err := ReadUint32(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, &Address{address, programHash, redeemScript, int(addrType), birthday, rescanHeight}, nil
+}
+
+func encodeTxHistory(history *TxHistory) []byte {
+	buf := new(bytes.Buffer)
+	WriteVarString(buf, history.TxID)
+	WriteUint32(buf, history.Height)
+	WriteUint32(buf, history.Time)
+	history.Amount.Serialize(buf)
+	history.Fee.Serialize(buf)
+	WriteVarUint(buf, uint64(len(history.Counterparties)))
+	for _, counterparty := range history.Counterparties {
+		WriteVarString(buf, counterparty)
+	}
+	WriteVarString(buf, history.Memo)
+	return buf.Bytes()
+}
+
+// decodeHistoryRecord decodes the record keyed by transaction ID and program hash
+func decodeHistoryRecord(key, value []byte) (*historyRecord, error) {
+	if len(key) < len(Uint168{}) {
+		return nil, errors.New("[Wallet], Invalid transaction history key")
+	}
+	var record historyRecord
+	copy(record.ProgramHash[:], key[len(key)-len(Uint168{}):])
+
+	reader := bytes.NewReader(value)
+	var err error
+	record.TxID, err = ReadVarString(reader)
+	if err != nil {
+		return nil, err
+	}
+	record.Height, err = ReadUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	record.Time, err = ReadUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	err = record.Amount.Deserialize(reader)
+	if err != nil {
+		return nil, err
+	}
+	err = record.Fee.Deserialize(reader)
+	if err != nil {
+		return nil, err
+	}
+	count, err := ReadVarUint(reader, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		counterparty, err := ReadVarString(reader)
+		if err != nil {
+			return nil, err
+		}
+		record.Counterparties = append(record.Counterparties, counterparty)
+	}
+	record.Memo, err = ReadVarString(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func encodeOutPoint(op *OutPoint) []byte {
+	buf := new(bytes.Buffer)
+	op.Serialize(buf)
+	return buf.Bytes()
+}
+
+func decodeOutPoint(key []byte) (*OutPoint, error) {
+	var op OutPoint
+	err := op.Deserialize(bytes.NewReader(key))
+	if err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+func encodeUint32(value uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, value)
+	return buf
+}
+
+func decodeUint32(value []byte) uint32 {
+	if len(value) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(value)
+}
@@ -0,0 +1,765 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	EncryptedDBName    = "wallet.enc"
+	EncryptedDBVersion = 1
+	// Blocks without wallet changes are saved at most this often, the unsaved ones are synced again
+	EncryptedSaveInterval = 10 * time.Second
+)
+
+// Parameters of the key derived from the wallet password
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+// plaintextFiles are the database files of the other backends and their backups in the wallet directory
+var plaintextFiles = []string{"wallet.db*", BoltDBName}
+
+var ErrDataStoreLocked = errors.New("[Wallet], Wallet data is encrypted, unlock it with the wallet password")
+
+// EncryptedDataStore keeps the data in memory and saves it to a file encrypted with
+// a key derived from the wallet password. It's opened locked and the data is loaded by UnlockData.
+type EncryptedDataStore struct {
+	*MemoryDataStore
+	DataSync
+
+	fileName string
+	// Held while the data is saved or the key changed
+	saveMutex sync.Mutex
+	salt      []byte
+	key       []byte
+	// If the block being applied changed the wallet data
+	dirty bool
+	// If blocks were committed after the last save, they are saved by Close
+	unsaved  bool
+	lastSave time.Time
+}
+
+func openEncryptedDataStore() (*EncryptedDataStore, error) {
+	return newEncryptedDataStore(filepath.Join(config.WalletDir(), EncryptedDBName)), nil
+}
+
+func newEncryptedDataStore(fileName string) *EncryptedDataStore {
+	store := &EncryptedDataStore{
+		MemoryDataStore: NewMemoryDataStore(),
+		fileName:        fileName,
+	}
+	// Synchronize through this store, so the changes are saved
+	store.DataSync = GetDataSync(store)
+	return store
+}
+
+// EncryptedDataStoreExists returns if the current wallet has an encrypted data store.
+func EncryptedDataStoreExists() bool {
+	_, err := os.Stat(filepath.Join(config.WalletDir(), EncryptedDBName))
+	return err == nil
+}
+
+func (store *EncryptedDataStore) IsDataLocked() bool {
+	store.saveMutex.Lock()
+	defer store.saveMutex.Unlock()
+
+	return store.key == nil
+}
+
+// UnlockData decrypts the data file with password and loads the data.
+func (store *EncryptedDataStore) UnlockData(password []byte) error {
+	store.saveMutex.Lock()
+	defer store.saveMutex.Unlock()
+
+	content, err := ioutil.ReadFile(store.fileName)
+	if err != nil {
+		return err
+	}
+	reader := bytes.NewReader(content)
+	version, err := ReadUint32(reader)
+	if err != nil {
+		return err
+	}
+	if version != EncryptedDBVersion {
+		return errors.New("[Wallet], Unsupported encrypted data version")
+	}
+	salt, err := ReadVarBytes(reader)
+	if err != nil {
+		return err
+	}
+	nonce, err := ReadVarBytes(reader)
+	if err != nil {
+		return err
+	}
+	sealed := content[len(content)-reader.Len():]
+
+	key, err := deriveKey(password, salt)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	plain, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return errors.New("[Wallet], Wrong password or the encrypted data is damaged")
+	}
+	data, err := decodeMemoryData(plain)
+	ClearBytes(plain)
+	if err != nil {
+		return err
+	}
+
+	store.MemoryDataStore.Lock()
+	store.data = data
+	store.MemoryDataStore.Unlock()
+	store.salt = salt
+	store.key = key
+	store.lastSave = time.Now()
+	return nil
+}
+
+// ChangePassword encrypts the data with the key derived from the new password. The data file encrypted with
+// the new password replaces the old one, which is kept until changeKeystore succeeds and restored if it fails,
+// so the keystore and the data are left encrypted with the old password when changing either of them fails.
+func (store *EncryptedDataStore) ChangePassword(newPassword []byte, changeKeystore func() error) error {
+	if store.IsDataLocked() {
+		return ErrDataStoreLocked
+	}
+	return store.setPassword(newPassword, changeKeystore)
+}
+
+// setPassword encrypts the data with the key derived from password, afterReplace is called
+// after the data file is replaced if it's not nil, and the old file is restored if it fails.
+func (store *EncryptedDataStore) setPassword(password []byte, afterReplace func() error) error {
+	salt := make([]byte, saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+	key, err := deriveKey(password, salt)
+	if err != nil {
+		return err
+	}
+
+	store.saveMutex.Lock()
+	defer store.saveMutex.Unlock()
+
+	tempFile, err := store.writeTempFile(salt, key)
+	if err != nil {
+		return err
+	}
+	// Keep the old file until the new one is in place and afterReplace succeeds
+	oldFile := store.fileName + ".old"
+	err = os.Rename(store.fileName, oldFile)
+	if os.IsNotExist(err) {
+		oldFile = ""
+	} else if err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	restore := func() {
+		if oldFile != "" {
+			os.Rename(oldFile, store.fileName)
+		}
+	}
+	err = os.Rename(tempFile, store.fileName)
+	if err != nil {
+		os.Remove(tempFile)
+		restore()
+		return err
+	}
+	if afterReplace != nil {
+		err = afterReplace()
+		if err != nil {
+			restore()
+			return err
+		}
+	}
+	if oldFile != "" {
+		os.Remove(oldFile)
+	}
+	store.salt = salt
+	store.key = key
+	store.saved()
+	return nil
+}
+
+// save writes the encrypted data to a new file and replaces the old one with it
+func (store *EncryptedDataStore) save() error {
+	store.saveMutex.Lock()
+	defer store.saveMutex.Unlock()
+
+	// The data of a locked store is not loaded, do not overwrite the file with it
+	if store.key == nil {
+		return ErrDataStoreLocked
+	}
+
+	tempFile, err := store.writeTempFile(store.salt, store.key)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempFile, store.fileName)
+	if err != nil {
+		return err
+	}
+	store.saved()
+	return nil
+}
+
+// writeTempFile writes the data encrypted with key to a temporary file next to the data file,
+// it's called with saveMutex held.
+func (store *EncryptedDataStore) writeTempFile(salt, key []byte) (string, error) {
+	store.MemoryDataStore.Lock()
+	plain := encodeMemoryData(store.data)
+	store.MemoryDataStore.Unlock()
+	defer ClearBytes(plain)
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	WriteUint32(buf, EncryptedDBVersion)
+	WriteVarBytes(buf, salt)
+	WriteVarBytes(buf, nonce)
+	buf.Write(aead.Seal(nil, nonce, plain, nil))
+
+	tempFile := store.fileName + ".tmp"
+	err = ioutil.WriteFile(tempFile, buf.Bytes(), 0600)
+	if err != nil {
+		return "", err
+	}
+	return tempFile, nil
+}
+
+// saved marks the data as saved, it's called with saveMutex held
+func (store *EncryptedDataStore) saved() {
+	store.dirty = false
+	store.unsaved = false
+	store.lastSave = time.Now()
+}
+
+// checkUnlocked returns ErrDataStoreLocked if the data is not loaded, so it's not read or changed empty
+func (store *EncryptedDataStore) checkUnlocked() error {
+	if store.IsDataLocked() {
+		return ErrDataStoreLocked
+	}
+	return nil
+}
+
+// Close saves the blocks committed after the last save
+func (store *EncryptedDataStore) Close() error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+
+	if !store.unsaved {
+		return nil
+	}
+	return store.save()
+}
+
+func (store *EncryptedDataStore) ResetDataStore() error {
+	err := store.checkUnlocked()
+	if err != nil {
+		return err
+	}
+	err = store.MemoryDataStore.ResetDataStore()
+	if err != nil {
+		return err
+	}
+	return store.save()
+}
+
+func (store *EncryptedDataStore) AddAddress(programHash *Uint168, redeemScript []byte, addrType int, birthday uint32) error {
+	err := store.checkUnlocked()
+	if err != nil {
+		return err
+	}
+	err = store.MemoryDataStore.AddAddress(programHash, redeemScript, addrType, birthday)
+	if err != nil {
+		return err
+	}
+	return store.save()
+}
+
+// SetRescanHeight is saved with the block it's called in, or right away out of blocks
+func (store *EncryptedDataStore) SetRescanHeight(programHash *Uint168, height uint32) error {
+	err := store.checkUnlocked()
+	if err != nil {
+		return err
+	}
+	err = store.MemoryDataStore.SetRescanHeight(programHash, height)
+	if err != nil {
+		return err
+	}
+	if store.inBlock() {
+		store.dirty = true
+		return nil
+	}
+	return store.save()
+}
+
+func (store *EncryptedDataStore) DeleteAddress(programHash *Uint168) error {
+	err := store.checkUnlocked()
+	if err != nil {
+		return err
+	}
+	err = store.MemoryDataStore.DeleteAddress(programHash)
+	if err != nil {
+		return err
+	}
+	return store.save()
+}
+
+// AddAddressUTXO, DeleteUTXO and AddTxHistory are called in a block, which is saved when committed
+
+func (store *EncryptedDataStore) AddAddressUTXO(programHash *Uint168, utxo *UTXO, blockHeight uint32) error {
+	store.dirty = true
+	return store.MemoryDataStore.AddAddressUTXO(programHash, utxo, blockHeight)
+}
+
+func (store *EncryptedDataStore) DeleteUTXO(op *OutPoint, height uint32) error {
+	store.dirty = true
+	return store.MemoryDataStore.DeleteUTXO(op, height)
+}
+
+func (store *EncryptedDataStore) AddTxHistory(programHash *Uint168, history *TxHistory) error {
+	store.dirty = true
+	return store.MemoryDataStore.AddTxHistory(programHash, history)
+}
+
+func (store *EncryptedDataStore) SetUTXOFrozen(op *OutPoint, frozen bool) error {
+	err := store.checkUnlocked()
+	if err != nil {
+		return err
+	}
+	err = store.MemoryDataStore.SetUTXOFrozen(op, frozen)
+	if err != nil {
+		return err
+	}
+	return store.save()
+}
+
+func (store *EncryptedDataStore) SetUTXOLabel(op *OutPoint, label string) error {
+	err := store.checkUnlocked()
+	if err != nil {
+		return err
+	}
+	err = store.MemoryDataStore.SetUTXOLabel(op, label)
+	if err != nil {
+		return err
+	}
+	return store.save()
+}
+
+func (store *EncryptedDataStore) CheckRecords(repair bool) ([]*CheckIssue, error) {
+	err := store.checkUnlocked()
+	if err != nil {
+		return nil, err
+	}
+	issues, err := store.MemoryDataStore.CheckRecords(repair)
+	if err != nil || !repair || len(issues) == 0 {
+		return issues, err
+	}
+	return issues, store.save()
+}
+
+// The blocks are not applied to the data of a locked store, so synchronization fails until it's unlocked
+func (store *EncryptedDataStore) BeginBlock() error {
+	err := store.checkUnlocked()
+	if err != nil {
+		return err
+	}
+	return store.MemoryDataStore.BeginBlock()
+}
+
+// CommitBlock saves the data if the block changed it, or the last save is older than
+// EncryptedSaveInterval. Each save is a whole snapshot, so the saved data is always consistent.
+func (store *EncryptedDataStore) CommitBlock(height uint32, hash string) error {
+	err := store.MemoryDataStore.CommitBlock(height, hash)
+	if err != nil {
+		return err
+	}
+	if !store.dirty && time.Since(store.lastSave) < EncryptedSaveInterval {
+		store.unsaved = true
+		return nil
+	}
+	return store.save()
+}
+
+func (store *EncryptedDataStore) CommitRescan() error {
+	err := store.MemoryDataStore.CommitRescan()
+	if err != nil {
+		return err
+	}
+	return store.save()
+}
+
+func (store *EncryptedDataStore) AbortBlock() error {
+	store.dirty = false
+	return store.MemoryDataStore.AbortBlock()
+}
+
+func (store *EncryptedDataStore) RollbackBlock(height uint32) error {
+	err := store.checkUnlocked()
+	if err != nil {
+		return err
+	}
+	err = store.MemoryDataStore.RollbackBlock(height)
+	if err != nil {
+		return err
+	}
+	return store.save()
+}
+
+// The data of a locked store is not loaded, reading it returns ErrDataStoreLocked instead of nothing
+
+func (store *EncryptedDataStore) GetAddressInfo(programHash *Uint168) (*Address, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	return store.MemoryDataStore.GetAddressInfo(programHash)
+}
+
+func (store *EncryptedDataStore) GetAddresses() ([]*Address, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	return store.MemoryDataStore.GetAddresses()
+}
+
+func (store *EncryptedDataStore) GetUTXO(op *OutPoint) (*Uint168, *UTXO, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return nil, nil, err
+	}
+	return store.MemoryDataStore.GetUTXO(op)
+}
+
+func (store *EncryptedDataStore) GetOutPoints() ([]*OutPoint, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	return store.MemoryDataStore.GetOutPoints()
+}
+
+func (store *EncryptedDataStore) GetAddressUTXOs(programHash *Uint168) ([]*UTXO, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	return store.MemoryDataStore.GetAddressUTXOs(programHash)
+}
+
+func (store *EncryptedDataStore) GetAddressUTXOsAt(programHash *Uint168, height uint32) ([]*UTXO, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	return store.MemoryDataStore.GetAddressUTXOsAt(programHash, height)
+}
+
+func (store *EncryptedDataStore) GetAddressSpentUTXOs(programHash *Uint168) ([]*SpentUTXO, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	return store.MemoryDataStore.GetAddressSpentUTXOs(programHash)
+}
+
+func (store *EncryptedDataStore) GetTxHistory(filter *HistoryFilter) ([]*TxHistory, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	return store.MemoryDataStore.GetTxHistory(filter)
+}
+
+func (store *EncryptedDataStore) GetStoredBlockHash(height uint32) (string, error) {
+	if err := store.checkUnlocked(); err != nil {
+		return "", err
+	}
+	return store.MemoryDataStore.GetStoredBlockHash(height)
+}
+
+// EncryptDataStore copies the data of a plaintext data store to a new encrypted one and removes
+// the plaintext database files. The recent blocks are committed again as restoreData does,
+// so the blocks orphaned after the migration are rolled back.
+func EncryptDataStore(plain DataStore, password []byte) (*EncryptedDataStore, error) {
+	if _, ok := plain.(*EncryptedDataStore); ok {
+		return nil, errors.New("[Wallet], Wallet data is already encrypted")
+	}
+	store, err := openEncryptedDataStore()
+	if err != nil {
+		return nil, err
+	}
+	data, err := copyMemoryData(plain)
+	if err != nil {
+		return nil, err
+	}
+	memory := NewMemoryDataStore()
+	err = restoreData(memory, data)
+	if err != nil {
+		return nil, err
+	}
+	store.data = memory.data
+	err = store.setPassword(password, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	plain.Close()
+	for _, pattern := range plaintextFiles {
+		files, err := filepath.Glob(filepath.Join(config.WalletDir(), pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			err = os.Remove(file)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return store, nil
+}
+
+// copyMemoryData reads the addresses, UTXOs, spent UTXOs, transaction history, recent block hashes and height of store
+func copyMemoryData(store DataStore) (*memoryData, error) {
+	data := newMemoryData()
+	data.height = store.CurrentHeight(QueryHeightCode)
+
+	addresses, err := store.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+	data.addresses = addresses
+	for _, addr := range addresses {
+		utxos, err := store.GetAddressUTXOs(addr.ProgramHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, utxo := range utxos {
+			data.utxos[*utxo.Op] = &utxoRecord{*addr.ProgramHash, *utxo.Amount, utxo.LockTime, utxo.Height,
+				utxo.Frozen, utxo.Label}
+		}
+		spent, err := store.GetAddressSpentUTXOs(addr.ProgramHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, utxo := range spent {
+			data.spent[*utxo.Op] = &spentRecord{utxoRecord{*addr.ProgramHash, *utxo.Amount, utxo.LockTime,
+				utxo.Height, false, ""}, utxo.SpentHeight}
+		}
+		histories, err := store.GetTxHistory(&HistoryFilter{ProgramHash: addr.ProgramHash})
+		if err != nil {
+			return nil, err
+		}
+		for _, history := range histories {
+			data.histories[historyKey{history.TxID, *addr.ProgramHash}] = &historyRecord{*addr.ProgramHash, *history}
+		}
+	}
+	// The hashes of the blocks which may be rolled back
+	for height := data.height; height > 0 && len(data.blocks) <= MaxReorgDepth; height-- {
+		hash, err := store.GetStoredBlockHash(height - 1)
+		if err == ErrNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data.blocks[height-1] = hash
+	}
+	return data, nil
+}
+
+func deriveKey(password, salt []byte) ([]byte, error) {
+	return scrypt.Key(password, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encodeMemoryData serializes the data, the records are encoded as in the bolt backend
+func encodeMemoryData(data *memoryData) []byte {
+	buf := new(bytes.Buffer)
+	WriteUint32(buf, data.height)
+
+	WriteVarUint(buf, uint64(len(data.addresses)))
+	for i, addr := range data.addresses {
+		WriteVarBytes(buf, addr.ProgramHash.Bytes())
+		WriteVarBytes(buf, encodeAddress(uint64(i), addr.RedeemScript, addr.Type, addr.Birthday,
+			addr.RescanHeight))
+	}
+	WriteVarUint(buf, uint64(len(data.utxos)))
+	for op, record := range data.utxos {
+		WriteVarBytes(buf, encodeOutPoint(&op))
+		WriteVarBytes(buf, encodeUTXORecord(record))
+	}
+	WriteVarUint(buf, uint64(len(data.spent)))
+	for op, record := range data.spent {
+		WriteVarBytes(buf, encodeOutPoint(&op))
+		WriteVarBytes(buf, encodeSpentRecord(record))
+	}
+	WriteVarUint(buf, uint64(len(data.blocks)))
+	for height, hash := range data.blocks {
+		WriteUint32(buf, height)
+		WriteVarString(buf, hash)
+	}
+	WriteVarUint(buf, uint64(len(data.undoLog)))
+	for _, undo := range data.undoLog {
+		WriteVarBytes(buf, encodeUndoRecord(undo))
+	}
+	WriteVarUint(buf, uint64(len(data.histories)))
+	for key, record := range data.histories {
+		WriteVarBytes(buf, append([]byte(key.TxID), key.ProgramHash.Bytes()...))
+		WriteVarBytes(buf, encodeTxHistory(&record.TxHistory))
+	}
+	return buf.Bytes()
+}
+
+func decodeMemoryData(value []byte) (*memoryData, error) {
+	data := newMemoryData()
+	reader := bytes.NewReader(value)
+	var err error
+	data.height, err = ReadUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// readRecords reads count key and value pairs
+	readRecords := func(decode func(k, v []byte) error) error {
+		count, err := ReadVarUint(reader, 0)
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < count; i++ {
+			k, err := ReadVarBytes(reader)
+			if err != nil {
+				return err
+			}
+			v, err := ReadVarBytes(reader)
+			if err != nil {
+				return err
+			}
+			err = decode(k, v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = readRecords(func(k, v []byte) error {
+		_, addr, err := decodeAddress(k, v)
+		if err != nil {
+			return err
+		}
+		data.addresses = append(data.addresses, addr)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readRecords(func(k, v []byte) error {
+		op, err := decodeOutPoint(k)
+		if err != nil {
+			return err
+		}
+		record, err := decodeUTXORecord(v)
+		if err != nil {
+			return err
+		}
+		data.utxos[*op] = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readRecords(func(k, v []byte) error {
+		op, err := decodeOutPoint(k)
+		if err != nil {
+			return err
+		}
+		record, err := decodeSpentRecord(v)
+		if err != nil {
+			return err
+		}
+		data.spent[*op] = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := ReadVarUint(reader, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		height, err := ReadUint32(reader)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := ReadVarString(reader)
+		if err != nil {
+			return nil, err
+		}
+		data.blocks[height] = hash
+	}
+
+	count, err = ReadVarUint(reader, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		v, err := ReadVarBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		undo, err := decodeUndoRecord(v)
+		if err != nil {
+			return nil, err
+		}
+		data.undoLog = append(data.undoLog, undo)
+	}
+
+	err = readRecords(func(k, v []byte) error {
+		record, err := decodeHistoryRecord(k, v)
+		if err != nil {
+			return err
+		}
+		data.histories[historyKey{record.TxID, record.ProgramHash}] = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
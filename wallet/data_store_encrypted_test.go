@@ -0,0 +1,79 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+)
+
+func TestEncryptedDataStoreLocked(t *testing.T) {
+	defer setTestDataDir(t)()
+	err := config.SetWalletName("locked")
+	mustSucceed(t, err)
+	mustSucceed(t, os.MkdirAll(config.WalletDir(), 0700))
+	store, err := openEncryptedDataStore()
+	mustSucceed(t, err)
+	mustSucceed(t, store.setPassword([]byte("password"), nil))
+	mustSucceed(t, store.AddAddress(testProgramHash1, []byte{1}, TypeMaster, 0))
+
+	locked, err := openEncryptedDataStore()
+	mustSucceed(t, err)
+	if _, err := locked.GetAddresses(); err != ErrDataStoreLocked {
+		t.Fatal("read the locked data", err)
+	}
+	if _, err := locked.GetTxHistory(&HistoryFilter{}); err != ErrDataStoreLocked {
+		t.Fatal("read the locked history", err)
+	}
+	if err := locked.BeginBlock(); err != ErrDataStoreLocked {
+		t.Fatal("applied a block to the locked data", err)
+	}
+	if err := locked.AddAddress(testProgramHash2, []byte{2}, TypeStand, 0); err != ErrDataStoreLocked {
+		t.Fatal("changed the locked data", err)
+	}
+	if err := locked.SyncChainData(context.Background()); err != ErrDataStoreLocked {
+		t.Fatal("synchronized the locked data", err)
+	}
+
+	mustSucceed(t, locked.UnlockData([]byte("password")))
+	addresses, err := locked.GetAddresses()
+	mustSucceed(t, err)
+	if len(addresses) != 1 {
+		t.Fatal("unexpected addresses", addresses)
+	}
+}
+
+func TestEncryptedDataStoreChangePassword(t *testing.T) {
+	defer setTestDataDir(t)()
+	err := config.SetWalletName("password")
+	mustSucceed(t, err)
+	mustSucceed(t, os.MkdirAll(config.WalletDir(), 0700))
+	store, err := openEncryptedDataStore()
+	mustSucceed(t, err)
+	mustSucceed(t, store.setPassword([]byte("old"), nil))
+
+	// The data is still encrypted with the old password when the keystore fails
+	keystoreErr := errors.New("keystore failed")
+	err = store.ChangePassword([]byte("new"), func() error { return keystoreErr })
+	if err != keystoreErr {
+		t.Fatal("unexpected error", err)
+	}
+	for _, file := range []string{store.fileName + ".tmp", store.fileName + ".old"} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Fatal("temporary file is left", err)
+		}
+	}
+	reopened, err := openEncryptedDataStore()
+	mustSucceed(t, err)
+	mustSucceed(t, reopened.UnlockData([]byte("old")))
+
+	mustSucceed(t, store.ChangePassword([]byte("new"), func() error { return nil }))
+	reopened, err = openEncryptedDataStore()
+	mustSucceed(t, err)
+	if reopened.UnlockData([]byte("old")) == nil {
+		t.Fatal("unlocked with the old password")
+	}
+	mustSucceed(t, reopened.UnlockData([]byte("new")))
+}
@@ -0,0 +1,620 @@
+package wallet
+
+import (
+	"errors"
+	"sync"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+// MemoryDataStore keeps the data in memory, nothing is saved when the program exits,
+// it's used in tests and when the wallet data should not be left on disk.
+type MemoryDataStore struct {
+	sync.Mutex
+	DataSync
+
+	data *memoryData
+	// The changes of the block being applied, reverted by AbortBlock
+	journal *memoryJournal
+	// Held while a block is being applied or rolled back
+	blockMutex sync.Mutex
+}
+
+type memoryData struct {
+	height    uint32
+	addresses []*Address
+	utxos     map[OutPoint]*utxoRecord
+	spent     map[OutPoint]*spentRecord
+	blocks    map[uint32]string
+	undoLog   []*undoRecord
+	histories map[historyKey]*historyRecord
+}
+
+type historyKey struct {
+	TxID        string
+	ProgramHash Uint168
+}
+
+func openMemoryDataStore() (DataStore, error) {
+	return NewMemoryDataStore(), nil
+}
+
+func NewMemoryDataStore() *MemoryDataStore {
+	store := &MemoryDataStore{data: newMemoryData()}
+	store.DataSync = GetDataSync(store)
+	return store
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		utxos:     make(map[OutPoint]*utxoRecord),
+		spent:     make(map[OutPoint]*spentRecord),
+		blocks:    make(map[uint32]string),
+		histories: make(map[historyKey]*historyRecord),
+	}
+}
+
+// memoryJournal keeps the values the keys had before the block being applied changed them,
+// nil if the key was not set. The records are not changed once saved, and the address and
+// undo slices are only appended or replaced, so the journal shares them with the data.
+type memoryJournal struct {
+	height    uint32
+	addresses []*Address
+	undoLog   []*undoRecord
+	utxos     map[OutPoint]*utxoRecord
+	spent     map[OutPoint]*spentRecord
+	histories map[historyKey]*historyRecord
+}
+
+func newMemoryJournal(data *memoryData) *memoryJournal {
+	return &memoryJournal{
+		height:    data.height,
+		addresses: data.addresses,
+		undoLog:   data.undoLog,
+		utxos:     make(map[OutPoint]*utxoRecord),
+		spent:     make(map[OutPoint]*spentRecord),
+		histories: make(map[historyKey]*historyRecord),
+	}
+}
+
+// The save methods record the value of a key before its first change in the block,
+// they do nothing when no block is being applied.
+
+func (journal *memoryJournal) saveUTXO(data *memoryData, op OutPoint) {
+	if journal == nil {
+		return
+	}
+	if _, ok := journal.utxos[op]; !ok {
+		journal.utxos[op] = data.utxos[op]
+	}
+}
+
+func (journal *memoryJournal) saveSpent(data *memoryData, op OutPoint) {
+	if journal == nil {
+		return
+	}
+	if _, ok := journal.spent[op]; !ok {
+		journal.spent[op] = data.spent[op]
+	}
+}
+
+func (journal *memoryJournal) saveHistory(data *memoryData, key historyKey) {
+	if journal == nil {
+		return
+	}
+	if _, ok := journal.histories[key]; !ok {
+		journal.histories[key] = data.histories[key]
+	}
+}
+
+// revert restores the saved values of the changed keys
+func (journal *memoryJournal) revert(data *memoryData) {
+	data.height = journal.height
+	data.addresses = journal.addresses
+	data.undoLog = journal.undoLog
+	for op, record := range journal.utxos {
+		if record == nil {
+			delete(data.utxos, op)
+		} else {
+			data.utxos[op] = record
+		}
+	}
+	for op, record := range journal.spent {
+		if record == nil {
+			delete(data.spent, op)
+		} else {
+			data.spent[op] = record
+		}
+	}
+	for key, record := range journal.histories {
+		if record == nil {
+			delete(data.histories, key)
+		} else {
+			data.histories[key] = record
+		}
+	}
+}
+
+func (data *memoryData) getAddress(programHash *Uint168) (int, *Address) {
+	for i, addr := range data.addresses {
+		if addr.ProgramHash.IsEqual(*programHash) {
+			return i, addr
+		}
+	}
+	return -1, nil
+}
+
+// inBlock returns if a block is being applied
+func (store *MemoryDataStore) inBlock() bool {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.journal != nil
+}
+
+// Close does nothing, the data is dropped with the store
+func (store *MemoryDataStore) Close() error {
+	return nil
+}
+
+func (store *MemoryDataStore) ResetDataStore() error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	data := newMemoryData()
+	data.addresses = store.data.addresses
+	store.data = data
+	return nil
+}
+
+func (store *MemoryDataStore) CurrentHeight(height uint32) uint32 {
+	store.Lock()
+	defer store.Unlock()
+
+	if height > store.data.height {
+		// Received reset height code
+		if height == ResetHeightCode {
+			height = 0
+		}
+		store.data.height = height
+		return height
+	}
+	return store.data.height
+}
+
+func (store *MemoryDataStore) AddAddress(programHash *Uint168, redeemScript []byte, addrType int, birthday uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if _, addr := store.data.getAddress(programHash); addr != nil {
+		return errors.New("[Wallet], Address already exists")
+	}
+	address, err := programHash.ToAddress()
+	if err != nil {
+		return err
+	}
+	store.data.addresses = append(store.data.addresses, &Address{address, programHash, redeemScript, addrType, birthday,
+		NoRescan})
+	return nil
+}
+
+// SetRescanHeight saves a changed copy of the address in a new slice, the saved ones are shared with the journal
+func (store *MemoryDataStore) SetRescanHeight(programHash *Uint168, height uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	index, addr := store.data.getAddress(programHash)
+	if addr == nil {
+		return ErrNotFound
+	}
+	changed := *addr
+	changed.RescanHeight = height
+	addresses := append([]*Address(nil), store.data.addresses...)
+	addresses[index] = &changed
+	store.data.addresses = addresses
+	return nil
+}
+
+func (store *MemoryDataStore) DeleteAddress(programHash *Uint168) error {
+	store.Lock()
+	defer store.Unlock()
+
+	index, addr := store.data.getAddress(programHash)
+	if addr == nil {
+		return ErrNotFound
+	}
+
+	// Delete UTXOs, spent UTXOs, undo records and transaction history of this address
+	for op, utxo := range store.data.utxos {
+		if utxo.ProgramHash.IsEqual(*programHash) {
+			store.journal.saveUTXO(store.data, op)
+			delete(store.data.utxos, op)
+		}
+	}
+	for op, spent := range store.data.spent {
+		if spent.ProgramHash.IsEqual(*programHash) {
+			store.journal.saveSpent(store.data, op)
+			delete(store.data.spent, op)
+		}
+	}
+	var undoLog []*undoRecord
+	for _, undo := range store.data.undoLog {
+		if !undo.ProgramHash.IsEqual(*programHash) {
+			undoLog = append(undoLog, undo)
+		}
+	}
+	store.data.undoLog = undoLog
+	for key := range store.data.histories {
+		if key.ProgramHash.IsEqual(*programHash) {
+			store.journal.saveHistory(store.data, key)
+			delete(store.data.histories, key)
+		}
+	}
+
+	// Delete address
+	addresses := store.data.addresses
+	store.data.addresses = append(addresses[:index:index], addresses[index+1:]...)
+	return nil
+}
+
+func (store *MemoryDataStore) GetAddressInfo(programHash *Uint168) (*Address, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	_, addr := store.data.getAddress(programHash)
+	if addr == nil {
+		return nil, ErrNotFound
+	}
+	info := *addr
+	return &info, nil
+}
+
+func (store *MemoryDataStore) GetAddresses() ([]*Address, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var addresses []*Address
+	for _, addr := range store.data.addresses {
+		info := *addr
+		addresses = append(addresses, &info)
+	}
+	return addresses, nil
+}
+
+func (store *MemoryDataStore) AddAddressUTXO(programHash *Uint168, utxo *UTXO, blockHeight uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if _, addr := store.data.getAddress(programHash); addr == nil {
+		return ErrNotFound
+	}
+	if _, ok := store.data.utxos[*utxo.Op]; ok {
+		return errors.New("[Wallet], UTXO already exists")
+	}
+	record := &utxoRecord{*programHash, *utxo.Amount, utxo.LockTime, utxo.Height, false, ""}
+	store.journal.saveUTXO(store.data, *utxo.Op)
+	store.data.utxos[*utxo.Op] = record
+	// Record undo information in case the block was orphaned
+	store.data.undoLog = append(store.data.undoLog, &undoRecord{blockHeight, *utxo.Op, *record, false})
+	return nil
+}
+
+func (store *MemoryDataStore) DeleteUTXO(op *OutPoint, height uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	// Skip if it's not in this wallet
+	record, ok := store.data.utxos[*op]
+	if !ok {
+		return nil
+	}
+	store.journal.saveUTXO(store.data, *op)
+	delete(store.data.utxos, *op)
+	// Record undo information in case the block was orphaned
+	store.data.undoLog = append(store.data.undoLog, &undoRecord{height, *op, *record, true})
+	// Keep the spent UTXO for the balance at past heights
+	store.journal.saveSpent(store.data, *op)
+	store.data.spent[*op] = &spentRecord{*record, height}
+	return nil
+}
+
+func (store *MemoryDataStore) GetUTXO(op *OutPoint) (*Uint168, *UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	record, ok := store.data.utxos[*op]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	programHash := record.ProgramHash
+	return &programHash, record.toUTXO(op), nil
+}
+
+func (store *MemoryDataStore) GetOutPoints() ([]*OutPoint, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var ops []*OutPoint
+	for op := range store.data.utxos {
+		ops = append(ops, NewOutPoint(op.TxID, op.Index))
+	}
+	return ops, nil
+}
+
+func (store *MemoryDataStore) GetAddressUTXOs(programHash *Uint168) ([]*UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var utxos []*UTXO
+	for op, record := range store.data.utxos {
+		if record.ProgramHash.IsEqual(*programHash) {
+			utxos = append(utxos, record.toUTXO(&op))
+		}
+	}
+	return utxos, nil
+}
+
+// GetAddressUTXOsAt returns the UTXOs of the address after the block at height was applied
+func (store *MemoryDataStore) GetAddressUTXOsAt(programHash *Uint168, height uint32) ([]*UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var utxos []*UTXO
+	for op, record := range store.data.utxos {
+		if record.ProgramHash.IsEqual(*programHash) && record.Height <= height {
+			utxos = append(utxos, record.toUTXO(&op))
+		}
+	}
+	for op, record := range store.data.spent {
+		if record.ProgramHash.IsEqual(*programHash) && record.unspentAt(height) {
+			utxos = append(utxos, record.toUTXO(&op))
+		}
+	}
+	return utxos, nil
+}
+
+func (store *MemoryDataStore) GetAddressSpentUTXOs(programHash *Uint168) ([]*SpentUTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var utxos []*SpentUTXO
+	for op, record := range store.data.spent {
+		if record.ProgramHash.IsEqual(*programHash) {
+			utxos = append(utxos, record.toSpentUTXO(&op))
+		}
+	}
+	return utxos, nil
+}
+
+func (store *MemoryDataStore) SetUTXOFrozen(op *OutPoint, frozen bool) error {
+	return store.updateUTXO(op, func(record *utxoRecord) { record.Frozen = frozen })
+}
+
+func (store *MemoryDataStore) SetUTXOLabel(op *OutPoint, label string) error {
+	return store.updateUTXO(op, func(record *utxoRecord) { record.Label = label })
+}
+
+// updateUTXO saves a changed copy of the UTXO record, the saved records are shared with the journal
+func (store *MemoryDataStore) updateUTXO(op *OutPoint, update func(record *utxoRecord)) error {
+	store.Lock()
+	defer store.Unlock()
+
+	record, ok := store.data.utxos[*op]
+	if !ok {
+		return ErrNotFound
+	}
+	changed := *record
+	update(&changed)
+	store.journal.saveUTXO(store.data, *op)
+	store.data.utxos[*op] = &changed
+	return nil
+}
+
+// CheckRecords finds the records of addresses not in the wallet and the UTXOs both unspent and spent
+func (store *MemoryDataStore) CheckRecords(repair bool) ([]*CheckIssue, error) {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	data := store.data
+	isOrphan := func(programHash *Uint168) bool {
+		_, addr := data.getAddress(programHash)
+		return addr == nil
+	}
+	var issues []*CheckIssue
+	for op, record := range data.utxos {
+		if isOrphan(&record.ProgramHash) {
+			issues = append(issues, orphanIssue("UTXO "+FormatOutPoint(&op)))
+			if repair {
+				delete(data.utxos, op)
+			}
+		}
+	}
+	for op, record := range data.spent {
+		if isOrphan(&record.ProgramHash) {
+			issues = append(issues, orphanIssue("spent UTXO "+FormatOutPoint(&op)))
+		} else if _, ok := data.utxos[op]; ok {
+			issues = append(issues, duplicateIssue(&op))
+		} else {
+			continue
+		}
+		if repair {
+			delete(data.spent, op)
+		}
+	}
+	var undoLog []*undoRecord
+	for _, undo := range data.undoLog {
+		if isOrphan(&undo.ProgramHash) {
+			issues = append(issues, orphanIssue("undo record of UTXO "+FormatOutPoint(&undo.Op)))
+			continue
+		}
+		undoLog = append(undoLog, undo)
+	}
+	if repair {
+		data.undoLog = undoLog
+	}
+	for key := range data.histories {
+		if isOrphan(&key.ProgramHash) {
+			issues = append(issues, orphanIssue("transaction "+key.TxID))
+			if repair {
+				delete(data.histories, key)
+			}
+		}
+	}
+	return issues, nil
+}
+
+func (store *MemoryDataStore) AddTxHistory(programHash *Uint168, history *TxHistory) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if _, addr := store.data.getAddress(programHash); addr == nil {
+		return ErrNotFound
+	}
+	// Replace the record when the block is synced again
+	key := historyKey{history.TxID, *programHash}
+	store.journal.saveHistory(store.data, key)
+	store.data.histories[key] = &historyRecord{*programHash, *history}
+	return nil
+}
+
+func (store *MemoryDataStore) GetTxHistory(filter *HistoryFilter) ([]*TxHistory, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var histories []*historyRecord
+	for _, h := range store.data.histories {
+		histories = append(histories, h)
+	}
+	return filterTxHistory(histories, filter)
+}
+
+// BeginBlock starts a journal of the changed keys, the changes made until CommitBlock
+// are applied atomically along with the block hash and the wallet height.
+func (store *MemoryDataStore) BeginBlock() error {
+	store.blockMutex.Lock()
+	store.Lock()
+	defer store.Unlock()
+
+	store.journal = newMemoryJournal(store.data)
+	return nil
+}
+
+func (store *MemoryDataStore) CommitBlock(height uint32, hash string) error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	store.journal = nil
+	store.saveBlockHash(height, hash)
+	store.data.height = height + 1
+	return nil
+}
+
+// CommitRescan commits the changes of a rescanned block,
+// the block hash and wallet height are left untouched.
+func (store *MemoryDataStore) CommitRescan() error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	store.journal = nil
+	return nil
+}
+
+func (store *MemoryDataStore) AbortBlock() error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	store.journal.revert(store.data)
+	store.journal = nil
+	return nil
+}
+
+func (store *MemoryDataStore) saveBlockHash(height uint32, hash string) {
+	store.data.blocks[height] = hash
+	// Blocks deeper than max reorg depth will not be rolled back, remove them
+	if height > MaxReorgDepth {
+		for h := range store.data.blocks {
+			if h < height-MaxReorgDepth {
+				delete(store.data.blocks, h)
+			}
+		}
+		var undoLog []*undoRecord
+		for _, undo := range store.data.undoLog {
+			if undo.Height >= height-MaxReorgDepth {
+				undoLog = append(undoLog, undo)
+			}
+		}
+		store.data.undoLog = undoLog
+	}
+}
+
+func (store *MemoryDataStore) GetStoredBlockHash(height uint32) (string, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	hash, ok := store.data.blocks[height]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return hash, nil
+}
+
+func (store *MemoryDataStore) RollbackBlock(height uint32) error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	// Undo records are reverted in the reverse order they were made
+	undoLog := store.data.undoLog
+	for i := len(undoLog) - 1; i >= 0; i-- {
+		undo := undoLog[i]
+		if undo.Height != height {
+			continue
+		}
+		if undo.Spent {
+			// Restore the UTXO spent in orphaned block
+			if _, ok := store.data.utxos[undo.Op]; !ok {
+				record := undo.utxoRecord
+				store.data.utxos[undo.Op] = &record
+			}
+		} else {
+			// Remove the UTXO added in orphaned block
+			delete(store.data.utxos, undo.Op)
+		}
+	}
+
+	var kept []*undoRecord
+	for _, undo := range undoLog {
+		if undo.Height < height {
+			kept = append(kept, undo)
+		}
+	}
+	store.data.undoLog = kept
+	// The UTXOs spent in orphaned blocks are unspent again
+	for op, spent := range store.data.spent {
+		if spent.SpentHeight >= height {
+			delete(store.data.spent, op)
+		}
+	}
+	for h := range store.data.blocks {
+		if h >= height {
+			delete(store.data.blocks, h)
+		}
+	}
+	for key, h := range store.data.histories {
+		if h.Height >= height {
+			delete(store.data.histories, key)
+		}
+	}
+	// Set wallet height back to the orphaned block
+	store.data.height = height
+	return nil
+}
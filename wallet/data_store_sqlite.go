@@ -0,0 +1,1036 @@
+//go:build cgo
+// +build cgo
+
+package wallet
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+	"github.com/elastos/Elastos.ELA.Client/log"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	DriverName = "sqlite3"
+	DBName     = "wallet.db"
+)
+
+const (
+	CreateInfoTable = `CREATE TABLE IF NOT EXISTS Info (
+				Name VARCHAR(20) NOT NULL PRIMARY KEY,
+				Value BLOB
+			);`
+	CreateAddressesTable = `CREATE TABLE IF NOT EXISTS Addresses (
+				Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				ProgramHash BLOB UNIQUE NOT NULL,
+				RedeemScript BLOB UNIQUE NOT NULL,
+				Type INTEGER NOT NULL,
+				Birthday INTEGER NOT NULL DEFAULT 0,
+				RescanHeight INTEGER NOT NULL DEFAULT 4294967295
+			);`
+	CreateUTXOsTable = `CREATE TABLE IF NOT EXISTS UTXOs (
+				OutPoint BLOB NOT NULL PRIMARY KEY,
+				Amount BLOB NOT NULL,
+				LockTime INTEGER NOT NULL,
+				AddressId INTEGER NOT NULL,
+				Height INTEGER NOT NULL DEFAULT 0,
+				Frozen INTEGER NOT NULL DEFAULT 0,
+				Label TEXT NOT NULL DEFAULT '',
+				FOREIGN KEY(AddressId) REFERENCES Addresses(Id)
+			);`
+	CreateBlocksTable = `CREATE TABLE IF NOT EXISTS Blocks (
+				Height INTEGER NOT NULL PRIMARY KEY,
+				Hash VARCHAR(64) NOT NULL
+			);`
+	CreateUndoLogTable = `CREATE TABLE IF NOT EXISTS UndoLog (
+				Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				Height INTEGER NOT NULL,
+				OutPoint BLOB NOT NULL,
+				Amount BLOB NOT NULL,
+				LockTime INTEGER NOT NULL,
+				AddressId INTEGER NOT NULL,
+				Spent INTEGER NOT NULL,
+				UTXOHeight INTEGER NOT NULL DEFAULT 0,
+				UTXOFrozen INTEGER NOT NULL DEFAULT 0,
+				UTXOLabel TEXT NOT NULL DEFAULT ''
+			);`
+	CreateTransactionsTable = `CREATE TABLE IF NOT EXISTS Transactions (
+				TxID VARCHAR(64) NOT NULL,
+				AddressId INTEGER NOT NULL,
+				Height INTEGER NOT NULL,
+				Time INTEGER NOT NULL,
+				Amount INTEGER NOT NULL,
+				Fee INTEGER NOT NULL,
+				Counterparties TEXT NOT NULL,
+				Memo TEXT NOT NULL,
+				PRIMARY KEY(TxID, AddressId),
+				FOREIGN KEY(AddressId) REFERENCES Addresses(Id)
+			);`
+	CreateSpentUTXOsTable = `CREATE TABLE IF NOT EXISTS SpentUTXOs (
+				OutPoint BLOB NOT NULL PRIMARY KEY,
+				Amount BLOB NOT NULL,
+				LockTime INTEGER NOT NULL,
+				AddressId INTEGER NOT NULL,
+				Height INTEGER NOT NULL,
+				SpentHeight INTEGER NOT NULL,
+				FOREIGN KEY(AddressId) REFERENCES Addresses(Id)
+			);
+			CREATE INDEX IF NOT EXISTS SpentUTXOsAddressId ON SpentUTXOs(AddressId);`
+	CreateIndexes = `CREATE INDEX IF NOT EXISTS UTXOsAddressId ON UTXOs(AddressId);
+			CREATE INDEX IF NOT EXISTS UndoLogHeight ON UndoLog(Height);
+			CREATE INDEX IF NOT EXISTS TransactionsAddressId ON Transactions(AddressId);
+			CREATE INDEX IF NOT EXISTS TransactionsHeight ON Transactions(Height);`
+)
+
+// SQLiteDataStore is the data store saved in a SQLite database, it requires cgo.
+type SQLiteDataStore struct {
+	sync.Mutex
+	DataSync
+
+	*sql.DB
+	// The database transaction of the block being applied
+	tx *sql.Tx
+	// Prepared statements by query, and the ones bound to current transaction
+	stmts   map[string]*sql.Stmt
+	txStmts map[string]*sql.Stmt
+	// Held while a block is being applied or rolled back
+	blockMutex sync.Mutex
+}
+
+func openSQLiteDataStore() (DataStore, error) {
+	db, err := initDB()
+	if err != nil {
+		return nil, err
+	}
+	dataStore := &SQLiteDataStore{
+		DB:    db,
+		stmts: make(map[string]*sql.Stmt),
+	}
+
+	dataStore.DataSync = GetDataSync(dataStore)
+
+	return dataStore, nil
+}
+
+func init() {
+	storages[StorageSQLite] = openSQLiteDataStore
+}
+
+func initDB() (*sql.DB, error) {
+	err := os.MkdirAll(config.WalletDir(), 0700)
+	if err != nil {
+		return nil, err
+	}
+	fileName := filepath.Join(config.WalletDir(), DBName)
+	db, err := sql.Open(DriverName, fileName)
+	if err != nil {
+		log.Error("Open data db error:", err)
+		return nil, err
+	}
+	// Create or upgrade tables
+	err = migrate(db, fileName)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Close waits for the block being applied and closes the database
+func (store *SQLiteDataStore) Close() error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	for _, stmt := range store.stmts {
+		stmt.Close()
+	}
+	store.stmts = make(map[string]*sql.Stmt)
+	return store.DB.Close()
+}
+
+// prepare returns the cached prepared statement of the query,
+// bound to the current database transaction if there is one.
+func (store *SQLiteDataStore) prepare(query string) (*sql.Stmt, error) {
+	stmt, ok := store.stmts[query]
+	if !ok {
+		var err error
+		stmt, err = store.DB.Prepare(query)
+		if err != nil {
+			return nil, err
+		}
+		store.stmts[query] = stmt
+	}
+	if store.tx == nil {
+		return stmt, nil
+	}
+	txStmt, ok := store.txStmts[query]
+	if !ok {
+		txStmt = store.tx.Stmt(stmt)
+		store.txStmts[query] = txStmt
+	}
+	return txStmt, nil
+}
+
+func (store *SQLiteDataStore) setTx(tx *sql.Tx) {
+	store.tx = tx
+	store.txStmts = make(map[string]*sql.Stmt)
+}
+
+func (store *SQLiteDataStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := store.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Exec(args...)
+}
+
+func (store *SQLiteDataStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := store.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(args...)
+}
+
+func (store *SQLiteDataStore) queryRow(query string, args ...interface{}) *sql.Row {
+	stmt, err := store.prepare(query)
+	if err != nil {
+		// Let the error be returned by Row.Scan
+		if store.tx != nil {
+			return store.tx.QueryRow(query, args...)
+		}
+		return store.DB.QueryRow(query, args...)
+	}
+	return stmt.QueryRow(args...)
+}
+
+// ResetDataStore clears the data synchronized from the chain, the addresses are kept
+// and the wallet height is set to 0 to synchronize blocks again.
+func (store *SQLiteDataStore) ResetDataStore() error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM UTXOs;
+						DELETE FROM SpentUTXOs;
+						DELETE FROM Blocks;
+						DELETE FROM UndoLog;
+						DELETE FROM Transactions;`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE Info SET Value=? WHERE Name=?", uint32(0), "Height")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteDataStore) CurrentHeight(height uint32) uint32 {
+	store.Lock()
+	defer store.Unlock()
+
+	row := store.queryRow("SELECT Value FROM Info WHERE Name=?", "Height")
+	var storedHeight uint32
+	row.Scan(&storedHeight)
+
+	if height > storedHeight {
+		// Received reset height code
+		if height == ResetHeightCode {
+			height = 0
+		}
+		// Insert current height
+		_, err := store.exec("UPDATE Info SET Value=? WHERE Name=?", height, "Height")
+		if err != nil {
+			return uint32(0)
+		}
+		return height
+	}
+	return storedHeight
+}
+
+func (store *SQLiteDataStore) AddAddress(programHash *Uint168, redeemScript []byte, addrType int, birthday uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	sql := "INSERT INTO Addresses(ProgramHash, RedeemScript, Type, Birthday) values(?,?,?,?)"
+	_, err := store.exec(sql, programHash.Bytes(), redeemScript, addrType, birthday)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *SQLiteDataStore) SetRescanHeight(programHash *Uint168, height uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	result, err := store.exec("UPDATE Addresses SET RescanHeight=? WHERE ProgramHash=?", height, programHash.Bytes())
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *SQLiteDataStore) DeleteAddress(programHash *Uint168) error {
+	store.Lock()
+	defer store.Unlock()
+
+	// Find addressId by ProgramHash
+	row := store.queryRow("SELECT Id FROM Addresses WHERE ProgramHash=?", programHash.Bytes())
+	var addressId int
+	err := row.Scan(&addressId)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Delete UTXOs of this address
+	_, err = store.exec("DELETE FROM UTXOs WHERE AddressId=?", addressId)
+	if err != nil {
+		return err
+	}
+
+	// Delete spent UTXOs of this address
+	_, err = store.exec("DELETE FROM SpentUTXOs WHERE AddressId=?", addressId)
+	if err != nil {
+		return err
+	}
+
+	// Delete undo records of this address
+	_, err = store.exec("DELETE FROM UndoLog WHERE AddressId=?", addressId)
+	if err != nil {
+		return err
+	}
+
+	// Delete transaction history of this address
+	_, err = store.exec("DELETE FROM Transactions WHERE AddressId=?", addressId)
+	if err != nil {
+		return err
+	}
+
+	// Delete address from address table
+	_, err = store.exec("DELETE FROM Addresses WHERE Id=?", addressId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *SQLiteDataStore) GetAddressInfo(programHash *Uint168) (*Address, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	// Query address info by it's ProgramHash
+	row := store.queryRow(`SELECT RedeemScript, Type, Birthday, RescanHeight FROM Addresses WHERE ProgramHash=?`,
+		programHash.Bytes())
+	var redeemScript []byte
+	var addrType int
+	var birthday, rescanHeight uint32
+	err := row.Scan(&redeemScript, &addrType, &birthday, &rescanHeight)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	address, err := programHash.ToAddress()
+	if err != nil {
+		return nil, err
+	}
+	return &Address{address, programHash, redeemScript, addrType, birthday, rescanHeight}, nil
+}
+
+func (store *SQLiteDataStore) GetAddresses() ([]*Address, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	rows, err := store.query("SELECT ProgramHash, RedeemScript, Type, Birthday, RescanHeight FROM Addresses")
+	if err != nil {
+		log.Error("Get address query error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []*Address
+	for rows.Next() {
+		var programHashBytes []byte
+		var redeemScript []byte
+		var addrType int
+		var birthday, rescanHeight uint32
+		err = rows.Scan(&programHashBytes, &redeemScript, &addrType, &birthday, &rescanHeight)
+		if err != nil {
+			log.Error("Get address scan row:", err)
+			return nil, err
+		}
+		programHash, err := Uint168FromBytes(programHashBytes)
+		if err != nil {
+			return nil, err
+		}
+		address, err := programHash.ToAddress()
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, &Address{address, programHash, redeemScript, addrType, birthday, rescanHeight})
+	}
+	return addresses, nil
+}
+
+func (store *SQLiteDataStore) AddAddressUTXO(programHash *Uint168, utxo *UTXO, blockHeight uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	// Find addressId by ProgramHash
+	row := store.queryRow("SELECT Id FROM Addresses WHERE ProgramHash=?", programHash.Bytes())
+	var addressId int
+	err := row.Scan(&addressId)
+	if err != nil {
+		return err
+	}
+	// Serialize input
+	buf := new(bytes.Buffer)
+	utxo.Op.Serialize(buf)
+	opBytes := buf.Bytes()
+	// Serialize amount
+	buf = new(bytes.Buffer)
+	utxo.Amount.Serialize(buf)
+	amountBytes := buf.Bytes()
+	// Do insert
+	sql := "INSERT INTO UTXOs(OutPoint, Amount, LockTime, AddressId, Height) values(?,?,?,?,?)"
+	_, err = store.exec(sql, opBytes, amountBytes, utxo.LockTime, addressId, utxo.Height)
+	if err != nil {
+		return err
+	}
+	// Record undo information in case the block was orphaned
+	sql = "INSERT INTO UndoLog(Height, OutPoint, Amount, LockTime, AddressId, Spent, UTXOHeight) values(?,?,?,?,?,?,?)"
+	_, err = store.exec(sql, blockHeight, opBytes, amountBytes, utxo.LockTime, addressId, false, utxo.Height)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *SQLiteDataStore) DeleteUTXO(op *OutPoint, height uint32) error {
+	store.Lock()
+	defer store.Unlock()
+
+	// Serialize input
+	buf := new(bytes.Buffer)
+	op.Serialize(buf)
+	opBytes := buf.Bytes()
+	// Find the UTXO to be deleted, skip if it's not in this wallet
+	row := store.queryRow("SELECT Amount, LockTime, AddressId, Height, Frozen, Label FROM UTXOs WHERE OutPoint=?", opBytes)
+	var amountBytes []byte
+	var lockTime uint32
+	var addressId int
+	var utxoHeight uint32
+	var frozen bool
+	var label string
+	err := row.Scan(&amountBytes, &lockTime, &addressId, &utxoHeight, &frozen, &label)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Do delete
+	_, err = store.exec("DELETE FROM UTXOs WHERE OutPoint=?", opBytes)
+	if err != nil {
+		return err
+	}
+	// Record undo information in case the block was orphaned
+	_, err = store.exec(`INSERT INTO UndoLog(Height, OutPoint, Amount, LockTime, AddressId, Spent, UTXOHeight,
+				UTXOFrozen, UTXOLabel) values(?,?,?,?,?,?,?,?,?)`, height, opBytes, amountBytes, lockTime, addressId,
+		true, utxoHeight, frozen, label)
+	if err != nil {
+		return err
+	}
+	// Keep the spent UTXO for the balance at past heights
+	_, err = store.exec(`INSERT OR REPLACE INTO SpentUTXOs(OutPoint, Amount, LockTime, AddressId, Height, SpentHeight)
+				values(?,?,?,?,?,?)`, opBytes, amountBytes, lockTime, addressId, utxoHeight, height)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *SQLiteDataStore) GetUTXO(op *OutPoint) (*Uint168, *UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	// Serialize input
+	buf := new(bytes.Buffer)
+	op.Serialize(buf)
+	opBytes := buf.Bytes()
+	// Query UTXO and it's owner by OutPoint
+	row := store.queryRow(`SELECT UTXOs.Amount, UTXOs.LockTime, UTXOs.Height, UTXOs.Frozen, UTXOs.Label,
+ 								Addresses.ProgramHash FROM UTXOs INNER JOIN Addresses ON UTXOs.AddressId=Addresses.Id
+ 								WHERE UTXOs.OutPoint=?`, opBytes)
+	var amountBytes []byte
+	var lockTime uint32
+	var height uint32
+	var frozen bool
+	var label string
+	var programHashBytes []byte
+	err := row.Scan(&amountBytes, &lockTime, &height, &frozen, &label, &programHashBytes)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var amount Fixed64
+	reader := bytes.NewReader(amountBytes)
+	amount.Deserialize(reader)
+
+	programHash, err := Uint168FromBytes(programHashBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return programHash, &UTXO{NewOutPoint(op.TxID, op.Index), &amount, lockTime, height, frozen, label}, nil
+}
+
+func (store *SQLiteDataStore) GetOutPoints() ([]*OutPoint, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	rows, err := store.query("SELECT OutPoint FROM UTXOs")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*OutPoint
+	for rows.Next() {
+		var opBytes []byte
+		err = rows.Scan(&opBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		var op OutPoint
+		reader := bytes.NewReader(opBytes)
+		op.Deserialize(reader)
+
+		ops = append(ops, &op)
+	}
+	return ops, nil
+}
+
+func (store *SQLiteDataStore) GetAddressUTXOs(programHash *Uint168) ([]*UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	rows, err := store.query(`SELECT UTXOs.OutPoint, UTXOs.Amount, UTXOs.LockTime, UTXOs.Height, UTXOs.Frozen,
+ 								UTXOs.Label FROM UTXOs INNER JOIN Addresses ON UTXOs.AddressId=Addresses.Id
+ 								WHERE Addresses.ProgramHash=?`, programHash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inputs []*UTXO
+	for rows.Next() {
+		var opBytes []byte
+		var amountBytes []byte
+		var lockTime uint32
+		var height uint32
+		var frozen bool
+		var label string
+		err = rows.Scan(&opBytes, &amountBytes, &lockTime, &height, &frozen, &label)
+		if err != nil {
+			return nil, err
+		}
+
+		var op OutPoint
+		reader := bytes.NewReader(opBytes)
+		op.Deserialize(reader)
+
+		var amount Fixed64
+		reader = bytes.NewReader(amountBytes)
+		amount.Deserialize(reader)
+
+		inputs = append(inputs, &UTXO{&op, &amount, lockTime, height, frozen, label})
+	}
+	return inputs, nil
+}
+
+// GetAddressUTXOsAt returns the UTXOs of the address after the block at height was applied
+func (store *SQLiteDataStore) GetAddressUTXOsAt(programHash *Uint168, height uint32) ([]*UTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	rows, err := store.query(`SELECT UTXOs.OutPoint, UTXOs.Amount, UTXOs.LockTime, UTXOs.Height FROM UTXOs
+ 								INNER JOIN Addresses ON UTXOs.AddressId=Addresses.Id
+ 								WHERE Addresses.ProgramHash=? AND UTXOs.Height<=?
+ 							UNION ALL
+ 							SELECT SpentUTXOs.OutPoint, SpentUTXOs.Amount, SpentUTXOs.LockTime, SpentUTXOs.Height
+ 								FROM SpentUTXOs INNER JOIN Addresses ON SpentUTXOs.AddressId=Addresses.Id
+ 								WHERE Addresses.ProgramHash=? AND SpentUTXOs.Height<=? AND SpentUTXOs.SpentHeight>?`,
+		programHash.Bytes(), height, programHash.Bytes(), height, height)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inputs []*UTXO
+	for rows.Next() {
+		var opBytes []byte
+		var amountBytes []byte
+		var lockTime uint32
+		var utxoHeight uint32
+		err = rows.Scan(&opBytes, &amountBytes, &lockTime, &utxoHeight)
+		if err != nil {
+			return nil, err
+		}
+
+		var op OutPoint
+		reader := bytes.NewReader(opBytes)
+		op.Deserialize(reader)
+
+		var amount Fixed64
+		reader = bytes.NewReader(amountBytes)
+		amount.Deserialize(reader)
+
+		inputs = append(inputs, &UTXO{Op: &op, Amount: &amount, LockTime: lockTime, Height: utxoHeight})
+	}
+	return inputs, nil
+}
+
+func (store *SQLiteDataStore) GetAddressSpentUTXOs(programHash *Uint168) ([]*SpentUTXO, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	rows, err := store.query(`SELECT SpentUTXOs.OutPoint, SpentUTXOs.Amount, SpentUTXOs.LockTime, SpentUTXOs.Height,
+ 								SpentUTXOs.SpentHeight FROM SpentUTXOs INNER JOIN Addresses
+ 								ON SpentUTXOs.AddressId=Addresses.Id WHERE Addresses.ProgramHash=?`, programHash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var utxos []*SpentUTXO
+	for rows.Next() {
+		var opBytes []byte
+		var amountBytes []byte
+		var utxo SpentUTXO
+		err = rows.Scan(&opBytes, &amountBytes, &utxo.LockTime, &utxo.Height, &utxo.SpentHeight)
+		if err != nil {
+			return nil, err
+		}
+
+		var op OutPoint
+		reader := bytes.NewReader(opBytes)
+		op.Deserialize(reader)
+
+		var amount Fixed64
+		reader = bytes.NewReader(amountBytes)
+		amount.Deserialize(reader)
+
+		utxo.Op = &op
+		utxo.Amount = &amount
+		utxos = append(utxos, &utxo)
+	}
+	return utxos, nil
+}
+
+func (store *SQLiteDataStore) SetUTXOFrozen(op *OutPoint, frozen bool) error {
+	return store.updateUTXO("UPDATE UTXOs SET Frozen=? WHERE OutPoint=?", op, frozen)
+}
+
+func (store *SQLiteDataStore) SetUTXOLabel(op *OutPoint, label string) error {
+	return store.updateUTXO("UPDATE UTXOs SET Label=? WHERE OutPoint=?", op, label)
+}
+
+// updateUTXO runs the update query setting value to the UTXO of op
+func (store *SQLiteDataStore) updateUTXO(query string, op *OutPoint, value interface{}) error {
+	store.Lock()
+	defer store.Unlock()
+
+	// Serialize input
+	buf := new(bytes.Buffer)
+	op.Serialize(buf)
+	result, err := store.exec(query, value, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sqliteRecordChecks are the queries of the inconsistent records, the first column identifies a record
+var sqliteRecordChecks = []struct {
+	kind   string
+	record string
+	table  string
+	column string
+	where  string
+}{
+	{IssueOrphan, "UTXO", "UTXOs", "OutPoint", "AddressId NOT IN (SELECT Id FROM Addresses)"},
+	{IssueOrphan, "spent UTXO", "SpentUTXOs", "OutPoint", "AddressId NOT IN (SELECT Id FROM Addresses)"},
+	{IssueDuplicate, "spent UTXO", "SpentUTXOs", "OutPoint", "OutPoint IN (SELECT OutPoint FROM UTXOs)"},
+	{IssueOrphan, "undo record of UTXO", "UndoLog", "OutPoint", "AddressId NOT IN (SELECT Id FROM Addresses)"},
+	{IssueOrphan, "transaction", "Transactions", "TxID", "AddressId NOT IN (SELECT Id FROM Addresses)"},
+}
+
+// CheckRecords finds the rows of addresses not in the wallet and the UTXOs both unspent and spent
+func (store *SQLiteDataStore) CheckRecords(repair bool) ([]*CheckIssue, error) {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	var issues []*CheckIssue
+	for _, check := range sqliteRecordChecks {
+		rows, err := tx.Query("SELECT " + check.column + " FROM " + check.table + " WHERE " + check.where)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		for rows.Next() {
+			var value []byte
+			err = rows.Scan(&value)
+			if err != nil {
+				rows.Close()
+				tx.Rollback()
+				return nil, err
+			}
+			id := string(value)
+			var op OutPoint
+			if check.column == "OutPoint" && op.Deserialize(bytes.NewReader(value)) == nil {
+				id = FormatOutPoint(&op)
+			}
+			if check.kind == IssueDuplicate {
+				issues = append(issues, duplicateIssue(&op))
+			} else {
+				issues = append(issues, orphanIssue(check.record+" "+id))
+			}
+		}
+		rows.Close()
+
+		if repair {
+			_, err = tx.Exec("DELETE FROM " + check.table + " WHERE " + check.where)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+	return issues, tx.Commit()
+}
+
+func (store *SQLiteDataStore) AddTxHistory(programHash *Uint168, history *TxHistory) error {
+	store.Lock()
+	defer store.Unlock()
+
+	// Find addressId by ProgramHash
+	row := store.queryRow("SELECT Id FROM Addresses WHERE ProgramHash=?", programHash.Bytes())
+	var addressId int
+	err := row.Scan(&addressId)
+	if err != nil {
+		return err
+	}
+	// Do insert, replace the record when the block is synced again
+	sql := `INSERT OR REPLACE INTO Transactions(TxID, AddressId, Height, Time, Amount, Fee, Counterparties, Memo)
+				values(?,?,?,?,?,?,?,?)`
+	_, err = store.exec(sql, history.TxID, addressId, history.Height, history.Time, int64(history.Amount),
+		int64(history.Fee), strings.Join(history.Counterparties, ","), history.Memo)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *SQLiteDataStore) GetTxHistory(filter *HistoryFilter) ([]*TxHistory, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	query := `SELECT Transactions.TxID, Addresses.ProgramHash, Transactions.Height, Transactions.Time,
+				Transactions.Amount, Transactions.Fee, Transactions.Counterparties, Transactions.Memo FROM Transactions
+				INNER JOIN Addresses ON Transactions.AddressId=Addresses.Id WHERE 1=1`
+	var args []interface{}
+	if filter.ProgramHash != nil {
+		query += " AND Addresses.ProgramHash=?"
+		args = append(args, filter.ProgramHash.Bytes())
+	}
+	if filter.FromHeight > 0 {
+		query += " AND Transactions.Height>=?"
+		args = append(args, filter.FromHeight)
+	}
+	if filter.ToHeight > 0 {
+		query += " AND Transactions.Height<=?"
+		args = append(args, filter.ToHeight)
+	}
+	if filter.FromTime > 0 {
+		query += " AND Transactions.Time>=?"
+		args = append(args, filter.FromTime)
+	}
+	if filter.ToTime > 0 {
+		query += " AND Transactions.Time<=?"
+		args = append(args, filter.ToTime)
+	}
+	query += " ORDER BY Transactions.Height, Transactions.TxID"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := store.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var histories []*TxHistory
+	for rows.Next() {
+		var history TxHistory
+		var programHashBytes []byte
+		var amount, fee int64
+		var counterparties string
+		err = rows.Scan(&history.TxID, &programHashBytes, &history.Height, &history.Time,
+			&amount, &fee, &counterparties, &history.Memo)
+		if err != nil {
+			return nil, err
+		}
+		programHash, err := Uint168FromBytes(programHashBytes)
+		if err != nil {
+			return nil, err
+		}
+		history.Address, err = programHash.ToAddress()
+		if err != nil {
+			return nil, err
+		}
+		history.Amount = Fixed64(amount)
+		history.Fee = Fixed64(fee)
+		if counterparties != "" {
+			history.Counterparties = strings.Split(counterparties, ",")
+		}
+		histories = append(histories, &history)
+	}
+	return histories, nil
+}
+
+// BeginBlock starts a database transaction, the changes made until CommitBlock
+// are applied atomically along with the block hash and the wallet height.
+func (store *SQLiteDataStore) BeginBlock() error {
+	store.blockMutex.Lock()
+	store.Lock()
+	defer store.Unlock()
+
+	tx, err := store.DB.Begin()
+	if err != nil {
+		store.blockMutex.Unlock()
+		return err
+	}
+	store.setTx(tx)
+	return nil
+}
+
+func (store *SQLiteDataStore) CommitBlock(height uint32, hash string) error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	tx := store.tx
+	defer store.setTx(nil)
+
+	err := store.saveBlockHash(height, hash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Update wallet height
+	_, err = store.exec("UPDATE Info SET Value=? WHERE Name=?", height+1, "Height")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// CommitRescan commits the changes of a rescanned block,
+// the block hash and wallet height are left untouched.
+func (store *SQLiteDataStore) CommitRescan() error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	tx := store.tx
+	store.setTx(nil)
+	return tx.Commit()
+}
+
+func (store *SQLiteDataStore) AbortBlock() error {
+	store.Lock()
+	defer store.Unlock()
+	defer store.blockMutex.Unlock()
+
+	tx := store.tx
+	store.setTx(nil)
+	return tx.Rollback()
+}
+
+func (store *SQLiteDataStore) saveBlockHash(height uint32, hash string) error {
+	_, err := store.exec("INSERT OR REPLACE INTO Blocks(Height, Hash) values(?,?)", height, hash)
+	if err != nil {
+		return err
+	}
+	// Blocks deeper than max reorg depth will not be rolled back, remove them
+	if height > MaxReorgDepth {
+		_, err = store.exec("DELETE FROM Blocks WHERE Height<?", height-MaxReorgDepth)
+		if err != nil {
+			return err
+		}
+		_, err = store.exec("DELETE FROM UndoLog WHERE Height<?", height-MaxReorgDepth)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *SQLiteDataStore) GetStoredBlockHash(height uint32) (string, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	row := store.queryRow("SELECT Hash FROM Blocks WHERE Height=?", height)
+	var hash string
+	err := row.Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func (store *SQLiteDataStore) RollbackBlock(height uint32) error {
+	store.blockMutex.Lock()
+	defer store.blockMutex.Unlock()
+	store.Lock()
+	defer store.Unlock()
+
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	store.setTx(tx)
+	defer store.setTx(nil)
+
+	err = store.rollbackBlock(height)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteDataStore) rollbackBlock(height uint32) error {
+	type undo struct {
+		opBytes     []byte
+		amountBytes []byte
+		lockTime    uint32
+		addressId   int
+		spent       bool
+		utxoHeight  uint32
+		utxoFrozen  bool
+		utxoLabel   string
+	}
+	// Undo records are reverted in the reverse order they were made
+	rows, err := store.query(`SELECT OutPoint, Amount, LockTime, AddressId, Spent, UTXOHeight, UTXOFrozen, UTXOLabel
+								FROM UndoLog WHERE Height=? ORDER BY Id DESC`, height)
+	if err != nil {
+		return err
+	}
+	var undos []*undo
+	for rows.Next() {
+		var u undo
+		err = rows.Scan(&u.opBytes, &u.amountBytes, &u.lockTime, &u.addressId, &u.spent, &u.utxoHeight,
+			&u.utxoFrozen, &u.utxoLabel)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		undos = append(undos, &u)
+	}
+	rows.Close()
+
+	for _, u := range undos {
+		if u.spent {
+			// Restore the UTXO spent in orphaned block
+			_, err = store.exec(`INSERT OR IGNORE INTO UTXOs(OutPoint, Amount, LockTime, AddressId, Height, Frozen, Label)
+						values(?,?,?,?,?,?,?)`, u.opBytes, u.amountBytes, u.lockTime, u.addressId, u.utxoHeight,
+				u.utxoFrozen, u.utxoLabel)
+		} else {
+			// Remove the UTXO added in orphaned block
+			_, err = store.exec("DELETE FROM UTXOs WHERE OutPoint=?", u.opBytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The UTXOs spent in orphaned blocks are unspent again
+	_, err = store.exec("DELETE FROM SpentUTXOs WHERE SpentHeight>=?", height)
+	if err != nil {
+		return err
+	}
+	_, err = store.exec("DELETE FROM UndoLog WHERE Height>=?", height)
+	if err != nil {
+		return err
+	}
+	_, err = store.exec("DELETE FROM Blocks WHERE Height>=?", height)
+	if err != nil {
+		return err
+	}
+	_, err = store.exec("DELETE FROM Transactions WHERE Height>=?", height)
+	if err != nil {
+		return err
+	}
+	// Set wallet height back to the orphaned block
+	_, err = store.exec("UPDATE Info SET Value=? WHERE Name=?", height, "Height")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package wallet
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+	"github.com/elastos/Elastos.ELA.Client/log"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+var (
+	testProgramHash1 = &Uint168{1}
+	testProgramHash2 = &Uint168{2}
+	testOutPoint1    = NewOutPoint(Uint256{1}, 0)
+	testOutPoint2    = NewOutPoint(Uint256{2}, 1)
+)
+
+// dataStoreTests run on a new store of every backend, with the addresses 1 and 2 added
+var dataStoreTests = []struct {
+	name string
+	test func(t *testing.T, store DataStore)
+}{
+	{"AddDeleteAddress", testAddDeleteAddress},
+	{"AddSpendUTXO", testAddSpendUTXO},
+	{"CommitAbortBlock", testCommitAbortBlock},
+	{"RollbackBlock", testRollbackBlock},
+	{"HistoryFilter", testHistoryFilter},
+	{"RescanHeight", testRescanHeight},
+}
+
+func TestDataStores(t *testing.T) {
+	defer setTestDataDir(t)()
+
+	for backend, open := range testDataStoreBackends() {
+		for _, test := range dataStoreTests {
+			t.Run(backend+"/"+test.name, func(t *testing.T) {
+				store := openTestDataStore(t, backend+"-"+test.name, open)
+				defer store.Close()
+
+				mustSucceed(t, store.AddAddress(testProgramHash1, []byte{1}, TypeMaster, 0))
+				mustSucceed(t, store.AddAddress(testProgramHash2, []byte{2}, TypeStand, 7))
+				test.test(t, store)
+			})
+		}
+	}
+}
+
+// setTestDataDir sets a temporary data directory, which is removed by the returned function
+func setTestDataDir(tb testing.TB) func() {
+	log.InitLog()
+	dir, err := ioutil.TempDir("", "wallet-test")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	config.SetDataDir(dir)
+	return func() {
+		config.SetDataDir("")
+		config.SetWalletName("")
+		os.RemoveAll(dir)
+	}
+}
+
+// testDataStoreBackends returns the backends in storages and the encrypted store, which is opened unlocked
+func testDataStoreBackends() map[string]func() (DataStore, error) {
+	backends := map[string]func() (DataStore, error){
+		"encrypted": func() (DataStore, error) {
+			store, err := openEncryptedDataStore()
+			if err != nil {
+				return nil, err
+			}
+			return store, store.setPassword([]byte("password"), nil)
+		},
+	}
+	for name, open := range storages {
+		backends[name] = open
+	}
+	return backends
+}
+
+// openTestDataStore opens a new store in the directory of the named wallet
+func openTestDataStore(tb testing.TB, walletName string, open func() (DataStore, error)) DataStore {
+	tb.Helper()
+	err := config.SetWalletName(walletName)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	err = os.MkdirAll(config.WalletDir(), 0700)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	store, err := open()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return store
+}
+
+func mustSucceed(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testUTXO(op *OutPoint, amount Fixed64, height uint32) *UTXO {
+	return &UTXO{Op: op, Amount: &amount, Height: height}
+}
+
+// applyTestBlocks adds UTXO 1 of address 1 in block 0, spends it and adds UTXO 2 of address 2 in block 1
+func applyTestBlocks(t *testing.T, store DataStore) {
+	t.Helper()
+	mustSucceed(t, store.BeginBlock())
+	mustSucceed(t, store.AddAddressUTXO(testProgramHash1, testUTXO(testOutPoint1, 100, 0), 0))
+	mustSucceed(t, store.AddTxHistory(testProgramHash1, &TxHistory{TxID: "01", Height: 0, Time: 10, Amount: 100,
+		Counterparties: []string{"a", "b"}, Memo: "memo"}))
+	mustSucceed(t, store.CommitBlock(0, "hash0"))
+
+	mustSucceed(t, store.BeginBlock())
+	mustSucceed(t, store.DeleteUTXO(testOutPoint1, 1))
+	mustSucceed(t, store.AddAddressUTXO(testProgramHash2, testUTXO(testOutPoint2, 50, 1), 1))
+	mustSucceed(t, store.AddTxHistory(testProgramHash1, &TxHistory{TxID: "02", Height: 1, Time: 20, Amount: -100}))
+	mustSucceed(t, store.AddTxHistory(testProgramHash2, &TxHistory{TxID: "02", Height: 1, Time: 20, Amount: 50}))
+	mustSucceed(t, store.CommitBlock(1, "hash1"))
+}
+
+func testAddDeleteAddress(t *testing.T, store DataStore) {
+	if store.AddAddress(testProgramHash1, []byte{1}, TypeMaster, 0) == nil {
+		t.Fatal("added an existing address")
+	}
+	addresses, err := store.GetAddresses()
+	mustSucceed(t, err)
+	if len(addresses) != 2 || !addresses[0].ProgramHash.IsEqual(*testProgramHash1) {
+		t.Fatal("unexpected addresses", addresses)
+	}
+	info, err := store.GetAddressInfo(testProgramHash2)
+	mustSucceed(t, err)
+	if info.Type != TypeStand || info.Birthday != 7 {
+		t.Fatal("unexpected address info", info)
+	}
+
+	applyTestBlocks(t, store)
+	mustSucceed(t, store.DeleteAddress(testProgramHash2))
+	if _, err := store.GetAddressInfo(testProgramHash2); err != ErrNotFound {
+		t.Fatal("deleted address found", err)
+	}
+	if _, _, err := store.GetUTXO(testOutPoint2); err != ErrNotFound {
+		t.Fatal("UTXO of deleted address found", err)
+	}
+	histories, err := store.GetTxHistory(&HistoryFilter{ProgramHash: testProgramHash2})
+	mustSucceed(t, err)
+	if len(histories) != 0 {
+		t.Fatal("history of deleted address found", histories)
+	}
+	if err := store.DeleteAddress(testProgramHash2); err != ErrNotFound {
+		t.Fatal("deleted a missing address", err)
+	}
+}
+
+func testAddSpendUTXO(t *testing.T, store DataStore) {
+	applyTestBlocks(t, store)
+	if store.CurrentHeight(QueryHeightCode) != 2 {
+		t.Fatal("unexpected height", store.CurrentHeight(QueryHeightCode))
+	}
+	if _, _, err := store.GetUTXO(testOutPoint1); err != ErrNotFound {
+		t.Fatal("spent UTXO found", err)
+	}
+	programHash, utxo, err := store.GetUTXO(testOutPoint2)
+	mustSucceed(t, err)
+	if !programHash.IsEqual(*testProgramHash2) || *utxo.Amount != 50 || utxo.Height != 1 {
+		t.Fatal("unexpected UTXO", programHash, utxo)
+	}
+	outPoints, err := store.GetOutPoints()
+	mustSucceed(t, err)
+	if len(outPoints) != 1 {
+		t.Fatal("unexpected outpoints", outPoints)
+	}
+
+	spent, err := store.GetAddressSpentUTXOs(testProgramHash1)
+	mustSucceed(t, err)
+	if len(spent) != 1 || spent[0].SpentHeight != 1 || *spent[0].Amount != 100 {
+		t.Fatal("unexpected spent UTXOs", spent)
+	}
+	// The spent UTXO is in the balance before the block spending it
+	utxos, err := store.GetAddressUTXOsAt(testProgramHash1, 0)
+	mustSucceed(t, err)
+	if len(utxos) != 1 {
+		t.Fatal("unexpected UTXOs at height 0", utxos)
+	}
+	utxos, err = store.GetAddressUTXOsAt(testProgramHash1, 1)
+	mustSucceed(t, err)
+	if len(utxos) != 0 {
+		t.Fatal("unexpected UTXOs at height 1", utxos)
+	}
+
+	mustSucceed(t, store.SetUTXOFrozen(testOutPoint2, true))
+	mustSucceed(t, store.SetUTXOLabel(testOutPoint2, "label"))
+	utxos, err = store.GetAddressUTXOs(testProgramHash2)
+	mustSucceed(t, err)
+	if len(utxos) != 1 || !utxos[0].Frozen || utxos[0].Label != "label" {
+		t.Fatal("unexpected frozen UTXO", utxos)
+	}
+	if err := store.SetUTXOFrozen(testOutPoint1, true); err != ErrNotFound {
+		t.Fatal("froze a spent UTXO", err)
+	}
+}
+
+func testCommitAbortBlock(t *testing.T, store DataStore) {
+	applyTestBlocks(t, store)
+	hash, err := store.GetStoredBlockHash(1)
+	mustSucceed(t, err)
+	if hash != "hash1" {
+		t.Fatal("unexpected block hash", hash)
+	}
+
+	// None of the changes of an aborted block are kept
+	mustSucceed(t, store.BeginBlock())
+	mustSucceed(t, store.DeleteUTXO(testOutPoint2, 2))
+	mustSucceed(t, store.AddAddressUTXO(testProgramHash1, testUTXO(testOutPoint1, 10, 2), 2))
+	mustSucceed(t, store.AddTxHistory(testProgramHash2, &TxHistory{TxID: "03", Height: 2, Time: 30, Amount: -50}))
+	mustSucceed(t, store.AbortBlock())
+	if store.CurrentHeight(QueryHeightCode) != 2 {
+		t.Fatal("aborted block changed the height")
+	}
+	if _, _, err := store.GetUTXO(testOutPoint2); err != nil {
+		t.Fatal("aborted block spent the UTXO", err)
+	}
+	if _, _, err := store.GetUTXO(testOutPoint1); err != ErrNotFound {
+		t.Fatal("aborted block added the UTXO", err)
+	}
+	spent, err := store.GetAddressSpentUTXOs(testProgramHash2)
+	mustSucceed(t, err)
+	if len(spent) != 0 {
+		t.Fatal("aborted block saved the spent UTXO", spent)
+	}
+	histories, err := store.GetTxHistory(&HistoryFilter{})
+	mustSucceed(t, err)
+	if len(histories) != 3 {
+		t.Fatal("aborted block saved the history", histories)
+	}
+	if _, err := store.GetStoredBlockHash(2); err != ErrNotFound {
+		t.Fatal("aborted block saved the hash", err)
+	}
+
+	// A rescanned block keeps the wallet height
+	mustSucceed(t, store.BeginBlock())
+	mustSucceed(t, store.AddAddressUTXO(testProgramHash1, testUTXO(testOutPoint1, 10, 0), 0))
+	mustSucceed(t, store.CommitRescan())
+	if store.CurrentHeight(QueryHeightCode) != 2 {
+		t.Fatal("rescan changed the height")
+	}
+	if _, _, err := store.GetUTXO(testOutPoint1); err != nil {
+		t.Fatal("rescan lost the UTXO", err)
+	}
+}
+
+func testRollbackBlock(t *testing.T, store DataStore) {
+	applyTestBlocks(t, store)
+	mustSucceed(t, store.SetUTXOLabel(testOutPoint2, "label"))
+	// A UTXO found by block 2 may be created in an earlier block
+	op := NewOutPoint(Uint256{3}, 0)
+	mustSucceed(t, store.BeginBlock())
+	mustSucceed(t, store.DeleteUTXO(testOutPoint2, 2))
+	mustSucceed(t, store.AddAddressUTXO(testProgramHash1, testUTXO(op, 30, 1), 2))
+	mustSucceed(t, store.CommitBlock(2, "hash2"))
+	_, utxo, err := store.GetUTXO(op)
+	mustSucceed(t, err)
+	if utxo.Height != 1 {
+		t.Fatal("unexpected UTXO height", utxo.Height)
+	}
+
+	// The UTXO spent by the orphaned block is restored with its label
+	mustSucceed(t, store.RollbackBlock(2))
+	_, utxo, err = store.GetUTXO(testOutPoint2)
+	mustSucceed(t, err)
+	if utxo.Label != "label" {
+		t.Fatal("unexpected restored UTXO", utxo)
+	}
+	if _, _, err := store.GetUTXO(op); err != ErrNotFound {
+		t.Fatal("UTXO added by orphaned block found", err)
+	}
+
+	mustSucceed(t, store.RollbackBlock(1))
+	if store.CurrentHeight(QueryHeightCode) != 1 {
+		t.Fatal("unexpected height", store.CurrentHeight(QueryHeightCode))
+	}
+	if _, _, err := store.GetUTXO(testOutPoint1); err != nil {
+		t.Fatal("UTXO spent by orphaned block not restored", err)
+	}
+	if _, _, err := store.GetUTXO(testOutPoint2); err != ErrNotFound {
+		t.Fatal("UTXO added by orphaned block found", err)
+	}
+	spent, err := store.GetAddressSpentUTXOs(testProgramHash1)
+	mustSucceed(t, err)
+	if len(spent) != 0 {
+		t.Fatal("UTXO spent by orphaned block is still spent", spent)
+	}
+	if _, err := store.GetStoredBlockHash(1); err != ErrNotFound {
+		t.Fatal("hash of orphaned block found", err)
+	}
+	histories, err := store.GetTxHistory(&HistoryFilter{})
+	mustSucceed(t, err)
+	if len(histories) != 1 || histories[0].TxID != "01" {
+		t.Fatal("history of orphaned block found", histories)
+	}
+}
+
+func testHistoryFilter(t *testing.T, store DataStore) {
+	applyTestBlocks(t, store)
+	tests := []struct {
+		filter HistoryFilter
+		txIDs  []string
+	}{
+		{HistoryFilter{}, []string{"01", "02", "02"}},
+		{HistoryFilter{ProgramHash: testProgramHash2}, []string{"02"}},
+		{HistoryFilter{FromHeight: 1}, []string{"02", "02"}},
+		{HistoryFilter{ToHeight: 0}, []string{"01", "02", "02"}},
+		{HistoryFilter{FromTime: 11, ToTime: 20}, []string{"02", "02"}},
+		{HistoryFilter{ToTime: 10}, []string{"01"}},
+		{HistoryFilter{Offset: 1, Limit: 1}, []string{"02"}},
+	}
+	for i, test := range tests {
+		histories, err := store.GetTxHistory(&test.filter)
+		mustSucceed(t, err)
+		if len(histories) != len(test.txIDs) {
+			t.Fatalf("filter %d: unexpected histories %v", i, histories)
+		}
+		for j, history := range histories {
+			if history.TxID != test.txIDs[j] {
+				t.Fatalf("filter %d: unexpected histories %v", i, histories)
+			}
+		}
+	}
+
+	histories, err := store.GetTxHistory(&HistoryFilter{ProgramHash: testProgramHash1, ToHeight: 0})
+	mustSucceed(t, err)
+	history := histories[0]
+	if history.Amount != 100 || history.Memo != "memo" || len(history.Counterparties) != 2 {
+		t.Fatal("unexpected history", history)
+	}
+}
+
+func testRescanHeight(t *testing.T, store DataStore) {
+	info, err := store.GetAddressInfo(testProgramHash2)
+	mustSucceed(t, err)
+	if info.RescanHeight != NoRescan {
+		t.Fatal("new address has blocks to rescan", info.RescanHeight)
+	}
+	mustSucceed(t, store.SetRescanHeight(testProgramHash2, 0))
+
+	// The rescan height is saved with the block
+	mustSucceed(t, store.BeginBlock())
+	mustSucceed(t, store.SetRescanHeight(testProgramHash2, 5))
+	mustSucceed(t, store.AbortBlock())
+	info, err = store.GetAddressInfo(testProgramHash2)
+	mustSucceed(t, err)
+	if info.RescanHeight != 0 {
+		t.Fatal("aborted block saved the rescan height", info.RescanHeight)
+	}
+	mustSucceed(t, store.BeginBlock())
+	mustSucceed(t, store.SetRescanHeight(testProgramHash2, 5))
+	mustSucceed(t, store.CommitRescan())
+	addresses, err := store.GetAddresses()
+	mustSucceed(t, err)
+	if addresses[0].RescanHeight != NoRescan || addresses[1].RescanHeight != 5 {
+		t.Fatal("unexpected rescan heights", addresses[0].RescanHeight, addresses[1].RescanHeight)
+	}
+	if err := store.SetRescanHeight(&Uint168{3}, 0); err != ErrNotFound {
+		t.Fatal("set the rescan height of a missing address", err)
+	}
+}
@@ -1,24 +1,49 @@
 package wallet
 
 import (
+	"context"
 	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/elastos/Elastos.ELA.Client/config"
 	"github.com/elastos/Elastos.ELA.Client/log"
 	. "github.com/elastos/Elastos.ELA.Client/rpc"
 
-	"github.com/cheggaaa/pb"
 	. "github.com/elastos/Elastos.ELA.Utility/common"
+	"github.com/elastos/Elastos.ELA.Utility/crypto"
 	. "github.com/elastos/Elastos.ELA/core"
 )
 
+const (
+	BlocksPrefetchFactor = 2
+	// The times a transient RPC failure is retried, the delay between
+	// the attempts is doubled from SyncRetryDelay up to SyncMaxRetryDelay
+	SyncRetries       = 5
+	SyncRetryDelay    = time.Second
+	SyncMaxRetryDelay = 30 * time.Second
+)
+
 type DataSync interface {
-	SyncChainData()
+	SyncChainData(ctx context.Context) error
+	Rescan(ctx context.Context, addresses []*Address) error
+	// SetProgressHandler sets the handler of the progress events, it's called before synchronizing.
+	// Nothing is reported when it's nil, which is the default.
+	SetProgressHandler(handler ProgressHandler)
 }
 
 type DataSyncImpl struct {
 	DataStore
-	addresses []*Address
+	// The addresses in this wallet by address string
+	addresses map[string]*Address
+	// The outpoints of the UTXOs in this wallet
+	outPoints map[OutPoint]bool
+	// The transactions of the blocks below this height are not notified
+	notifyHeight uint32
+	// Receives the progress of synchronization and rescan
+	progressHandler ProgressHandler
 }
 
 func GetDataSync(dataStore DataStore) DataSync {
@@ -27,106 +52,668 @@ func GetDataSync(dataStore DataStore) DataSync {
 	}
 }
 
-func (sync *DataSyncImpl) SyncChainData() {
-	// Get the addresses in this wallet
-	sync.addresses, _ = sync.GetAddresses()
+func (sync *DataSyncImpl) SetProgressHandler(handler ProgressHandler) {
+	sync.progressHandler = handler
+}
 
-	var chainHeight uint32
-	var currentHeight uint32
-	var needSync bool
+// SyncChainData applies the blocks after the wallet height until the chain height. Every block is applied
+// in one database transaction, so when an error is returned or ctx is canceled, the wallet is left at the last
+// applied block, and the next synchronization resumes from there.
+func (sync *DataSyncImpl) SyncChainData(ctx context.Context) error {
+	// Events are delivered before returning
+	notifier := newNotifier(&config.Params().Notify)
+	defer notifier.Close()
+
+	// Finish the rescans stopped before, the rescanned addresses have no records above their rescan heights
+	addresses, err := sync.GetAddresses()
+	if err != nil {
+		return err
+	}
+	err = sync.Rescan(ctx, addresses)
+	if err != nil {
+		return err
+	}
+
+	if config.Params().SyncMode == config.SyncModeUnspent {
+		// No transaction is found in this mode
+		for _, event := range []string{EventIncoming, EventOutgoing, EventConfirmed} {
+			if notifier.Subscribed(event) {
+				return errors.New("[Wallet], The " + event + " event is not available in unspent sync mode, " +
+					"subscribe the block event only or use blocks sync mode")
+			}
+		}
+		err := sync.syncUnspent(ctx, notifier)
+		if !IsMethodNotFound(err) {
+			return err
+		}
+		log.Error("The node does not index unspent outputs, fall back to scanning blocks")
+	}
+
+	// Load the addresses and UTXOs in this wallet
+	err = sync.loadCache()
+	if err != nil {
+		return err
+	}
+
+	// A wallet synchronized from the first block, a new, reset or restored one, replays the chain
+	// history, the transactions of the blocks already in the chain when it starts are not notified
+	sync.notifyHeight = 0
+	replay := sync.CurrentHeight(QueryHeightCode) == 0
+
+	// The last block committed by this synchronization
+	var lastBlock *BlockInfo
+	// The block event is fired for the new tip only, not every block synchronized
+	defer func() {
+		if lastBlock != nil {
+			notifier.Notify(&NotifyEvent{Event: EventBlock, Height: lastBlock.Height, BlockHash: lastBlock.Hash})
+		}
+	}()
 
 	for {
-		chainHeight, currentHeight, needSync = sync.needSyncBlocks()
-		if !needSync {
-			break
+		chainHeight, currentHeight, needSync, err := sync.needSyncBlocks(ctx)
+		if err != nil || !needSync {
+			return err
 		}
-		bar := pb.StartNew(int(chainHeight - currentHeight + 1))
-		for currentHeight <= chainHeight {
-			hash, err := GetBlockHash(currentHeight)
-			if err != nil {
-				log.Error("Get block hash failed at height:", currentHeight, "error:", err)
-				os.Exit(1)
+		if replay {
+			sync.notifyHeight = chainHeight + 1
+			replay = false
+		}
+		block, err := sync.syncBlocks(ctx, notifier, currentHeight, chainHeight)
+		if block != nil {
+			lastBlock = block
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// syncBlocks applies the blocks in the height range and returns the last one applied,
+// it stops after rolling back the orphaned blocks if a chain reorganization is found.
+func (sync *DataSyncImpl) syncBlocks(ctx context.Context, notifier *Notifier, fromHeight, toHeight uint32) (*BlockInfo, error) {
+	var lastBlock *BlockInfo
+	progress := startProgress(sync.progressHandler, StageSync, fromHeight, toHeight)
+	quit := make(chan struct{})
+	defer close(quit)
+	for result := range fetchBlocks(ctx, fromHeight, toHeight, config.Params().SyncWorkers, quit) {
+		// Stop before the next block if ctx is canceled
+		if err := ctx.Err(); err != nil {
+			return lastBlock, err
+		}
+		fetched := <-result
+		if fetched.err != nil {
+			if err := ctx.Err(); err != nil {
+				return lastBlock, err
 			}
-			block, err := GetBlock(hash)
+			return lastBlock, errors.New(fmt.Sprint("[Wallet], Get block failed at height ", fetched.height, ", ", fetched.err))
+		}
+		block := fetched.block
+		// Check if the block builds on the previous one we have
+		orphaned, err := sync.isOrphaned(block)
+		if err != nil {
+			return lastBlock, err
+		}
+		if orphaned {
+			log.Info("Chain reorganization detected at height:", block.Height)
+			err := sync.rollback(ctx, block.Height-1)
 			if err != nil {
-				log.Error("Get block failed at height:", currentHeight, "error:", err)
-				os.Exit(1)
+				return lastBlock, err
+			}
+			progress.finish()
+			// Rollback restores UTXOs spent by orphaned blocks
+			return lastBlock, sync.loadCache()
+		}
+		histories, err := sync.applyBlock(block)
+		if err != nil {
+			return lastBlock, err
+		}
+		sync.notifyBlock(notifier, block, histories)
+		lastBlock = block
+		progress.increment(block.Height)
+	}
+	// The fetching also stops early if ctx is canceled
+	if err := ctx.Err(); err != nil {
+		return lastBlock, err
+	}
+	progress.finish()
+	return lastBlock, nil
+}
+
+// applyBlock applies the changes of block in one database transaction, which is aborted if any of them fails
+func (sync *DataSyncImpl) applyBlock(block *BlockInfo) ([]*TxHistory, error) {
+	err := sync.BeginBlock()
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("[Wallet], Begin block failed at height ", block.Height, ", ", err))
+	}
+	histories, err := sync.processBlock(block)
+	if err != nil {
+		sync.AbortBlock()
+		return nil, errors.New(fmt.Sprint("[Wallet], Process block failed at height ", block.Height, ", ", err))
+	}
+	// Commit block changes with block hash and wallet height
+	err = sync.CommitBlock(block.Height, block.Hash)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("[Wallet], Commit block failed at height ", block.Height, ", ", err))
+	}
+	return histories, nil
+}
+
+// Rescan replays the synchronized blocks for the given addresses from their rescan heights, only the
+// transactions of these addresses are recorded, existing data is left intact. The rescan heights are
+// saved with every replayed block, so the next synchronization resumes a rescan stopped by an error or ctx.
+func (sync *DataSyncImpl) Rescan(ctx context.Context, addresses []*Address) error {
+	var rescanning []*Address
+	for _, addr := range addresses {
+		if addr.RescanHeight != NoRescan {
+			rescanning = append(rescanning, addr)
+		}
+	}
+	if len(rescanning) == 0 {
+		return nil
+	}
+	if config.Params().SyncMode == config.SyncModeUnspent {
+		err := sync.rescanUnspent(ctx, rescanning)
+		if err == nil {
+			return sync.finishRescan(rescanning)
+		}
+		if !IsMethodNotFound(err) {
+			return err
+		}
+		log.Error("The node does not index unspent outputs, fall back to scanning blocks")
+	}
+	fromHeight := rescanning[0].RescanHeight
+	for _, addr := range rescanning {
+		if addr.RescanHeight < fromHeight {
+			fromHeight = addr.RescanHeight
+		}
+	}
+
+	// Blocks above current height will be processed by the next synchronization
+	currentHeight := sync.CurrentHeight(QueryHeightCode)
+	if fromHeight >= currentHeight {
+		return sync.finishRescan(rescanning)
+	}
+	toHeight := currentHeight - 1
+
+	// The UTXOs added before the rescan was stopped are spent by the replayed blocks
+	scanner := &DataSyncImpl{
+		DataStore: sync.DataStore,
+		outPoints: make(map[OutPoint]bool),
+	}
+	for _, addr := range rescanning {
+		utxos, err := sync.GetAddressUTXOs(addr.ProgramHash)
+		if err != nil {
+			return err
+		}
+		for _, utxo := range utxos {
+			scanner.outPoints[*utxo.Op] = true
+		}
+	}
+
+	progress := startProgress(sync.progressHandler, StageRescan, fromHeight, toHeight)
+	quit := make(chan struct{})
+	defer close(quit)
+	for result := range fetchBlocks(ctx, fromHeight, toHeight, config.Params().SyncWorkers, quit) {
+		// Stop before the next block if ctx is canceled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fetched := <-result
+		if fetched.err != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return errors.New(fmt.Sprint("[Wallet], Rescan get block failed at height ", fetched.height, ", ", fetched.err))
+		}
+		block := fetched.block
+		// Each address is replayed from its own rescan height
+		scanner.addresses = make(map[string]*Address)
+		for _, addr := range rescanning {
+			if addr.RescanHeight <= block.Height {
+				scanner.addresses[addr.Address] = addr
+			}
+		}
+		err := sync.BeginBlock()
+		if err != nil {
+			return err
+		}
+		err = scanner.rescanBlock(block)
+		if err != nil {
+			sync.AbortBlock()
+			return err
+		}
+		err = sync.CommitRescan()
+		if err != nil {
+			return err
+		}
+		for _, addr := range scanner.addresses {
+			addr.RescanHeight = block.Height + 1
+		}
+		progress.increment(block.Height)
+	}
+	// The fetching also stops early if ctx is canceled
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	progress.finish()
+	return sync.finishRescan(rescanning)
+}
+
+// rescanBlock processes block for the scanned addresses and saves their next rescan height with it
+func (sync *DataSyncImpl) rescanBlock(block *BlockInfo) error {
+	_, err := sync.processBlock(block)
+	if err != nil {
+		return err
+	}
+	for _, addr := range sync.addresses {
+		err = sync.SetRescanHeight(addr.ProgramHash, block.Height+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishRescan marks the addresses as rescanned, they are synchronized with the others from now on
+func (sync *DataSyncImpl) finishRescan(addresses []*Address) error {
+	for _, addr := range addresses {
+		err := sync.SetRescanHeight(addr.ProgramHash, NoRescan)
+		if err != nil {
+			return err
+		}
+		addr.RescanHeight = NoRescan
+	}
+	return nil
+}
+
+type fetchJob struct {
+	height uint32
+	result chan *fetchResult
+}
+
+var errFetchStopped = errors.New("[Wallet], Block fetching stopped")
+
+type fetchResult struct {
+	height uint32
+	block  *BlockInfo
+	err    error
+}
+
+// fetchBlocks downloads the blocks in the given height range with concurrent workers,
+// the results are delivered in height order, and at most workers * BlocksPrefetchFactor
+// blocks are fetched ahead of the consumer. Close quit or cancel ctx to stop fetching,
+// every delivered result channel receives a block or an error, so the consumer never blocks on it.
+func fetchBlocks(ctx context.Context, from, to uint32, workers int, quit <-chan struct{}) <-chan chan *fetchResult {
+	ordered := make(chan chan *fetchResult, workers*BlocksPrefetchFactor)
+	jobs := make(chan *fetchJob)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range jobs {
+				block, err := fetchBlock(ctx, job.height)
+				job.result <- &fetchResult{job.height, block, err}
 			}
-			sync.processBlock(block)
+		}()
+	}
 
-			// Update wallet height
-			currentHeight = sync.CurrentHeight(block.Height + 1)
-			bar.Increment()
+	go func() {
+		defer close(jobs)
+		defer close(ordered)
+		for height := from; height <= to; height++ {
+			result := make(chan *fetchResult, 1)
+			select {
+			case ordered <- result:
+			case <-quit:
+				return
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- &fetchJob{height, result}:
+			case <-quit:
+				result <- &fetchResult{height, nil, errFetchStopped}
+				return
+			case <-ctx.Done():
+				result <- &fetchResult{height, nil, ctx.Err()}
+				return
+			}
+		}
+	}()
+
+	return ordered
+}
+
+func fetchBlock(ctx context.Context, height uint32) (*BlockInfo, error) {
+	var block *BlockInfo
+	err := retry(ctx, func() error {
+		hash, err := GetBlockHash(height)
+		if err != nil {
+			return err
+		}
+		block, err = GetBlock(hash)
+		return err
+	})
+	return block, err
+}
+
+// retry calls the RPC until it succeeds, or fails with an error responded by the node,
+// the connection and response format errors are retried SyncRetries times with exponential backoff.
+func retry(ctx context.Context, call func() error) error {
+	delay := SyncRetryDelay
+	for i := 0; ; i++ {
+		err := call()
+		if err == nil || IsNodeError(err) || i >= SyncRetries {
+			return err
+		}
+		log.Error("RPC failed, retry in", delay, "error:", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+		if delay > SyncMaxRetryDelay {
+			delay = SyncMaxRetryDelay
+		}
+	}
+}
+
+func (sync *DataSyncImpl) isOrphaned(block *BlockInfo) (bool, error) {
+	if block.Height == 0 {
+		return false, nil
+	}
+	prevHash, err := sync.GetStoredBlockHash(block.Height - 1)
+	if err == ErrNotFound {
+		// No stored hash to compare with
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New(fmt.Sprint("[Wallet], Get stored block hash failed at height ", block.Height-1, ", ", err))
+	}
+	return !strings.EqualFold(prevHash, block.PreviousBlockHash), nil
+}
+
+// rollback walks back from the given height to the fork point,
+// and undo the changes made by orphaned blocks.
+func (sync *DataSyncImpl) rollback(ctx context.Context, height uint32) error {
+	for {
+		storedHash, err := sync.GetStoredBlockHash(height)
+		if err == ErrNotFound {
+			// The fork is deeper than the blocks we can roll back
+			return sync.resync(height)
 		}
-		bar.Finish()
+		if err != nil {
+			return errors.New(fmt.Sprint("[Wallet], Get stored block hash failed at height ", height, ", ", err))
+		}
+		var hash *Uint256
+		err = retry(ctx, func() (err error) {
+			hash, err = GetBlockHash(height)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.New(fmt.Sprint("[Wallet], Get block hash failed at height ", height, ", ", err))
+		}
+		if strings.EqualFold(storedHash, BytesToHexString(hash.Bytes())) {
+			// Fork point found
+			return nil
+		}
+		err = sync.RollbackBlock(height)
+		if err != nil {
+			return errors.New(fmt.Sprint("[Wallet], Rollback block failed at height ", height, ", ", err))
+		}
+		log.Info("Rollback orphaned block at height:", height)
+		if height == 0 {
+			return nil
+		}
+		height--
 	}
 }
 
-func (sync *DataSyncImpl) needSyncBlocks() (uint32, uint32, bool) {
+// resync resets the data store and synchronizes again from the first block, when a fork is found
+// deeper than MaxReorgDepth at the given height. The transactions of the blocks synchronized before
+// are notified already, they are not notified again.
+func (sync *DataSyncImpl) resync(height uint32) error {
+	log.Error("Fork point not found above height", height, ", reset wallet and synchronize from the first block")
+	currentHeight := sync.CurrentHeight(QueryHeightCode)
+	err := sync.ResetDataStore()
+	if err != nil {
+		return errors.New(fmt.Sprint("[Wallet], Reset data store failed, ", err))
+	}
+	if sync.notifyHeight < currentHeight {
+		sync.notifyHeight = currentHeight
+	}
+	return nil
+}
+
+func (sync *DataSyncImpl) needSyncBlocks(ctx context.Context) (uint32, uint32, bool, error) {
 
-	chainHeight, err := GetChainHeight()
+	var chainHeight uint32
+	err := retry(ctx, func() (err error) {
+		chainHeight, err = GetChainHeight()
+		return err
+	})
 	if err != nil {
-		return 0, 0, false
+		if ctx.Err() != nil {
+			return 0, 0, false, ctx.Err()
+		}
+		return 0, 0, false, errors.New(fmt.Sprint("[Wallet], Get chain height failed, ", err))
 	}
 
 	currentHeight := sync.CurrentHeight(QueryHeightCode)
 
 	if currentHeight >= chainHeight {
-		return chainHeight, currentHeight, false
+		return chainHeight, currentHeight, false, nil
 	}
 
-	return chainHeight, currentHeight, true
+	return chainHeight, currentHeight, true, nil
 }
 
-func (sync *DataSyncImpl) containAddress(address string) (*Address, bool) {
-	for _, addr := range sync.addresses {
-		if addr.Address == address {
-			return addr, true
+func (sync *DataSyncImpl) loadCache() error {
+	sync.addresses = make(map[string]*Address)
+	addresses, err := sync.GetAddresses()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addresses {
+		// The address joins the synchronization when its rescan is finished
+		if addr.RescanHeight == NoRescan {
+			sync.addresses[addr.Address] = addr
 		}
 	}
-	return nil, false
+
+	sync.outPoints = make(map[OutPoint]bool)
+	ops, err := sync.GetOutPoints()
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		sync.outPoints[*op] = true
+	}
+	return nil
 }
 
-func (sync *DataSyncImpl) processBlock(block *BlockInfo) {
+func (sync *DataSyncImpl) containAddress(address string) (*Address, bool) {
+	addr, ok := sync.addresses[address]
+	return addr, ok
+}
+
+// processBlock applies the transactions of block to the wallet, and returns the recorded transaction history
+func (sync *DataSyncImpl) processBlock(block *BlockInfo) ([]*TxHistory, error) {
+	var histories []*TxHistory
 	// Add UTXO to wallet address from transaction outputs
 	for _, txInfo := range block.Tx {
 		data, err := json.Marshal(txInfo)
 		if err != nil {
-			log.Error("Resolve transaction info failed")
-			os.Exit(1)
+			return nil, errors.New(fmt.Sprint("[Wallet], Resolve transaction info failed, ", err))
 		}
 		var tx TransactionInfo
 		err = json.Unmarshal(data, &tx)
 		if err != nil {
-			log.Error("Resolve transaction info failed")
-			os.Exit(1)
+			return nil, errors.New(fmt.Sprint("[Wallet], Resolve transaction info failed, ", err))
 		}
+		// The net amount of wallet addresses in this transaction
+		ledger := make(map[*Address]Fixed64)
+		var totalInput, totalOutput Fixed64
+		var allInputsKnown = len(tx.Inputs) > 0
+		// The first wallet address spending an input, the fee is recorded on its record only
+		var payer *Address
+		var recipients []string
+
+		referTxHash, err := decodeTxID(tx.Hash)
+		if err != nil {
+			return nil, err
+		}
+
 		// Add UTXOs to wallet address from transaction outputs
 		for index, output := range tx.Outputs {
+			amount, err := StringToFixed64(output.Value)
+			if err != nil {
+				return nil, errors.New(fmt.Sprint("[Wallet], Invalid output value ", output.Value, " of transaction ", tx.Hash))
+			}
+			totalOutput += *amount
 			if addr, ok := sync.containAddress(output.Address); ok {
 				// Create UTXO input from output
-				txHashBytes, _ := HexStringToBytes(tx.Hash)
-				referTxHash, _ := Uint256FromBytes(txHashBytes)
 				lockTime := output.OutputLock
 				if tx.TxType == CoinBase {
-					lockTime = block.Height + 100
+					lockTime = block.Height + config.CoinbaseMaturity
 				}
-				amount, _ := StringToFixed64(output.Value)
 				// Save UTXO input to data store
 				addressUTXO := &UTXO{
 					Op:       NewOutPoint(*referTxHash, uint16(index)),
 					Amount:   amount,
 					LockTime: lockTime,
+					Height:   block.Height,
+				}
+				err = sync.AddAddressUTXO(addr.ProgramHash, addressUTXO, block.Height)
+				if err != nil {
+					return nil, err
 				}
-				sync.AddAddressUTXO(addr.ProgramHash, addressUTXO)
+				sync.outPoints[*addressUTXO.Op] = true
+				ledger[addr] += *amount
+			} else {
+				recipients = append(recipients, output.Address)
 			}
 		}
 
 		// Delete UTXOs from wallet by transaction inputs
 		for _, input := range tx.Inputs {
-			txHashBytes, _ := HexStringToBytes(input.TxID)
-			referTxID, _ := Uint256FromBytes(txHashBytes)
-			sync.DeleteUTXO(NewOutPoint(*referTxID, input.VOut))
+			referTxID, err := decodeTxID(input.TxID)
+			if err != nil {
+				return nil, err
+			}
+			op := NewOutPoint(*referTxID, input.VOut)
+			// Skip the inputs not spending UTXOs in this wallet
+			if !sync.outPoints[*op] {
+				allInputsKnown = false
+				continue
+			}
+			if addr, utxo, ok := sync.getUTXO(op); ok {
+				totalInput += *utxo.Amount
+				ledger[addr] -= *utxo.Amount
+				if payer == nil {
+					payer = addr
+				}
+			} else {
+				allInputsKnown = false
+			}
+			err = sync.DeleteUTXO(op, block.Height)
+			if err != nil {
+				return nil, err
+			}
+			delete(sync.outPoints, *op)
+		}
+
+		// Record transaction history of wallet addresses, the counterparties of a payment are the recipients,
+		// and the ones of an incoming transaction are the senders
+		counterparties := recipients
+		if payer == nil {
+			counterparties = sync.inputOwners(tx.Programs)
+		}
+		var fee Fixed64
+		if allInputsKnown {
+			fee = totalInput - totalOutput
+		}
+		var memo string
+		for _, attr := range tx.Attributes {
+			if attr.Usage == Memo {
+				memoBytes, _ := HexStringToBytes(attr.Data)
+				memo = string(memoBytes)
+			}
+		}
+		for addr, amount := range ledger {
+			history := &TxHistory{
+				TxID:           tx.Hash,
+				Address:        addr.Address,
+				Height:         block.Height,
+				Time:           block.Time,
+				Amount:         amount,
+				Counterparties: counterparties,
+				Memo:           memo,
+			}
+			if addr == payer {
+				history.Fee = fee
+			}
+			err = sync.AddTxHistory(addr.ProgramHash, history)
+			if err != nil {
+				return nil, err
+			}
+			histories = append(histories, history)
+		}
+	}
+	return histories, nil
+}
+
+// inputOwners returns the addresses out of the wallet signing the inputs of a transaction,
+// each program of the transaction is the redeem script of an input owner.
+func (sync *DataSyncImpl) inputOwners(programs []ProgramInfo) []string {
+	var owners []string
+	for _, program := range programs {
+		code, err := HexStringToBytes(program.Code)
+		if err != nil {
+			continue
 		}
+		programHash, err := crypto.ToProgramHash(code)
+		if err != nil {
+			continue
+		}
+		address, err := programHash.ToAddress()
+		if err != nil {
+			continue
+		}
+		if _, ok := sync.containAddress(address); !ok {
+			owners = append(owners, address)
+		}
+	}
+	return owners
+}
+
+func decodeTxID(txID string) (*Uint256, error) {
+	txHashBytes, err := HexStringToBytes(txID)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("[Wallet], Invalid transaction id ", txID))
+	}
+	txHash, err := Uint256FromBytes(txHashBytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("[Wallet], Invalid transaction id ", txID))
+	}
+	return txHash, nil
+}
+
+func (sync *DataSyncImpl) getUTXO(op *OutPoint) (*Address, *UTXO, bool) {
+	programHash, utxo, err := sync.GetUTXO(op)
+	if err != nil {
+		return nil, nil, false
+	}
+	address, err := programHash.ToAddress()
+	if err != nil {
+		return nil, nil, false
+	}
+	addr, ok := sync.containAddress(address)
+	if !ok {
+		return nil, nil, false
 	}
+	return addr, utxo, true
 }
@@ -0,0 +1,106 @@
+package wallet
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/elastos/Elastos.ELA.Client/rpc"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+const (
+	// The wallet addresses receiving and spending in the generated blocks
+	benchAddresses = 10
+	// Every generated block has this many payments to the wallet, and as many spending
+	// the outputs the wallet received in the previous block
+	benchTxsPerBlock = 10
+)
+
+// BenchmarkApplyBlock applies b.N generated blocks on every data store backend
+func BenchmarkApplyBlock(b *testing.B) {
+	defer setTestDataDir(b)()
+
+	for backend, open := range testDataStoreBackends() {
+		b.Run(backend, func(b *testing.B) {
+			store := openTestDataStore(b, fmt.Sprint("bench-", backend, "-", b.N), open)
+			defer store.Close()
+
+			var addresses []string
+			for i := 0; i < benchAddresses; i++ {
+				programHash := &Uint168{1, byte(i)}
+				err := store.AddAddress(programHash, []byte{byte(i)}, TypeStand, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				address, err := programHash.ToAddress()
+				if err != nil {
+					b.Fatal(err)
+				}
+				addresses = append(addresses, address)
+			}
+			sync := &DataSyncImpl{DataStore: store}
+			err := sync.loadCache()
+			if err != nil {
+				b.Fatal(err)
+			}
+			blocks := generateBlocks(b.N, addresses)
+
+			b.ResetTimer()
+			for _, block := range blocks {
+				_, err := sync.applyBlock(block)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// generateBlocks returns count blocks paying to addresses, from the second block on
+// the payments of the previous block are spent to an address out of the wallet.
+func generateBlocks(count int, addresses []string) []*BlockInfo {
+	var blocks []*BlockInfo
+	var txCount int
+	newTxID := func() string {
+		txCount++
+		return fmt.Sprintf("%064x", txCount)
+	}
+	var previousTxIDs []string
+	for height := 0; height < count; height++ {
+		block := &BlockInfo{
+			Hash:   fmt.Sprintf("%064x", height+1),
+			Height: uint32(height),
+			Time:   uint32(height * 120),
+		}
+		var txIDs []string
+		for i := 0; i < benchTxsPerBlock; i++ {
+			txID := newTxID()
+			block.Tx = append(block.Tx, &TransactionInfo{
+				TxId:   txID,
+				Hash:   txID,
+				TxType: TransferAsset,
+				Inputs: []InputInfo{{TxID: newTxID()}},
+				Outputs: []OutputInfo{
+					{Value: "1", Address: addresses[i%len(addresses)]},
+					{Value: "1", Address: "external"},
+				},
+			})
+			txIDs = append(txIDs, txID)
+		}
+		for _, previousTxID := range previousTxIDs {
+			txID := newTxID()
+			block.Tx = append(block.Tx, &TransactionInfo{
+				TxId:    txID,
+				Hash:    txID,
+				TxType:  TransferAsset,
+				Inputs:  []InputInfo{{TxID: previousTxID}},
+				Outputs: []OutputInfo{{Value: "1", Address: "external"}},
+			})
+		}
+		previousTxIDs = txIDs
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
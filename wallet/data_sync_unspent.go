@@ -0,0 +1,195 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+	. "github.com/elastos/Elastos.ELA.Client/rpc"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+// unspentChanges are the differences between the UTXOs in the data store and the node
+type unspentChanges struct {
+	added []*addedUTXO
+	spent []*OutPoint
+}
+
+type addedUTXO struct {
+	programHash *Uint168
+	utxo        *UTXO
+}
+
+// syncUnspent synchronizes the UTXOs of the wallet addresses with the unspent outputs indexed by the node,
+// the changes are committed as the block at chain height. Transaction history is not recorded in this way,
+// so only the block event is fired.
+func (sync *DataSyncImpl) syncUnspent(ctx context.Context, notifier *Notifier) error {
+	chainHeight, _, needSync, err := sync.needSyncBlocks(ctx)
+	if err != nil || !needSync {
+		return err
+	}
+	var hash *Uint256
+	err = retry(ctx, func() (err error) {
+		hash, err = GetBlockHash(chainHeight)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	addresses, err := sync.GetAddresses()
+	if err != nil {
+		return err
+	}
+	// Query the node before changing the data store, so it's left intact if the node can not answer
+	changes, err := getUnspentChanges(ctx, sync, addresses, chainHeight)
+	if err != nil {
+		return err
+	}
+
+	err = sync.BeginBlock()
+	if err != nil {
+		return err
+	}
+	err = applyUnspentChanges(sync, changes, chainHeight)
+	if err != nil {
+		sync.AbortBlock()
+		return err
+	}
+	err = sync.CommitBlock(chainHeight, BytesToHexString(hash.Bytes()))
+	if err != nil {
+		return err
+	}
+	notifier.Notify(&NotifyEvent{Event: EventBlock, Height: chainHeight, BlockHash: BytesToHexString(hash.Bytes())})
+	return nil
+}
+
+// rescanUnspent adds the unspent outputs of the given addresses indexed by the node,
+// the wallet height is left untouched.
+func (sync *DataSyncImpl) rescanUnspent(ctx context.Context, addresses []*Address) error {
+	currentHeight := sync.CurrentHeight(QueryHeightCode)
+	if currentHeight == 0 {
+		return nil
+	}
+	syncedHeight := currentHeight - 1
+	changes, err := getUnspentChanges(ctx, sync, addresses, syncedHeight)
+	if err != nil {
+		return err
+	}
+
+	err = sync.BeginBlock()
+	if err != nil {
+		return err
+	}
+	err = applyUnspentChanges(sync, changes, syncedHeight)
+	if err != nil {
+		sync.AbortBlock()
+		return err
+	}
+	return sync.CommitRescan()
+}
+
+// getUnspentChanges compares the UTXOs of addresses in store with the unspent outputs on the node at chain height,
+// the error of a node not providing listunspent is returned as it is.
+func getUnspentChanges(ctx context.Context, store DataStore, addresses []*Address, chainHeight uint32) (*unspentChanges, error) {
+	changes := new(unspentChanges)
+	for _, addr := range addresses {
+		var unspents []UTXOInfo
+		err := retry(ctx, func() (err error) {
+			unspents, err = ListUnspent(addr.Address)
+			return err
+		})
+		if IsMethodNotFound(err) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprint("[Wallet], List unspent of ", addr.Address, " failed, ", err))
+		}
+		utxos, err := store.GetAddressUTXOs(addr.ProgramHash)
+		if err != nil {
+			return nil, err
+		}
+		stored := make(map[OutPoint]bool)
+		for _, utxo := range utxos {
+			stored[*utxo.Op] = true
+		}
+
+		unspentOps := make(map[OutPoint]bool)
+		for _, unspent := range unspents {
+			// Unconfirmed outputs will be added when they are in a block
+			if unspent.Confirmations == 0 {
+				continue
+			}
+			txHashBytes, err := HexStringToBytes(unspent.TxID)
+			if err != nil {
+				return nil, err
+			}
+			txHash, err := Uint256FromBytes(txHashBytes)
+			if err != nil {
+				return nil, err
+			}
+			op := NewOutPoint(*txHash, uint16(unspent.VOut))
+			unspentOps[*op] = true
+			if stored[*op] {
+				continue
+			}
+			utxo, err := newUnspentUTXO(ctx, op, &unspent, chainHeight)
+			if err != nil {
+				return nil, err
+			}
+			changes.added = append(changes.added, &addedUTXO{addr.ProgramHash, utxo})
+		}
+
+		for _, utxo := range utxos {
+			if !unspentOps[*utxo.Op] {
+				changes.spent = append(changes.spent, utxo.Op)
+			}
+		}
+	}
+	return changes, nil
+}
+
+// newUnspentUTXO creates the UTXO of an unspent output, the created height is worked out from confirmations
+func newUnspentUTXO(ctx context.Context, op *OutPoint, unspent *UTXOInfo, chainHeight uint32) (*UTXO, error) {
+	amount, err := StringToFixed64(unspent.Amount)
+	if err != nil {
+		return nil, err
+	}
+	var height uint32
+	if chainHeight+1 > unspent.Confirmations {
+		height = chainHeight + 1 - unspent.Confirmations
+	}
+	lockTime := unspent.OutputLock
+	// Coinbase outputs are locked until maturity, which is not given by the unspent output
+	var txInfo *TransactionInfo
+	err = retry(ctx, func() (err error) {
+		txInfo, err = GetTransaction(unspent.TxID)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("[Wallet], Get transaction ", unspent.TxID, " failed, ", err))
+	}
+	if txInfo.TxType == CoinBase {
+		lockTime = height + config.CoinbaseMaturity
+	}
+	return &UTXO{Op: op, Amount: amount, LockTime: lockTime, Height: height}, nil
+}
+
+// applyUnspentChanges records the changes as made by the block at height, the added UTXOs keep their created heights
+func applyUnspentChanges(store DataStore, changes *unspentChanges, height uint32) error {
+	for _, op := range changes.spent {
+		err := store.DeleteUTXO(op, height)
+		if err != nil {
+			return err
+		}
+	}
+	for _, added := range changes.added {
+		err := store.AddAddressUTXO(added.programHash, added.utxo, height)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package wallet
+
+import (
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+)
+
+// TxHistory is a transaction record of a wallet address
+type TxHistory struct {
+	TxID    string
+	Address string
+	Height  uint32
+	Time    uint32
+	// Net amount of the address, negative when paying
+	Amount Fixed64
+	// Fee is known only when all inputs are from this wallet, it is on the record of the first paying address only
+	Fee Fixed64
+	// Recipients out of the wallet when paying, or the senders signing the inputs when receiving
+	Counterparties []string
+	// Memo attribute of the transaction
+	Memo string
+}
+
+// HistoryFilter selects transaction records, zero value fields are ignored
+type HistoryFilter struct {
+	ProgramHash *Uint168
+	FromHeight  uint32
+	ToHeight    uint32
+	FromTime    uint32
+	ToTime      uint32
+	Offset      int
+	Limit       int
+}
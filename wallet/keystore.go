@@ -87,9 +87,6 @@ func ImportKeystore(name string, password []byte, privateKey []byte) error {
 	if err != nil {
 		return err
 	}
-	// Handle system interrupt signals
-	keystore.catchSystemSignals()
-
 	return nil
 }
 
@@ -142,9 +139,6 @@ func CreateKeystore(name string, password []byte) (Keystore, error) {
 	if err != nil {
 		return nil, err
 	}
-	// Handle system interrupt signals
-	keystore.catchSystemSignals()
-
 	return keystore, nil
 }
 
@@ -171,9 +165,6 @@ func OpenKeystore(name string, password []byte) (Keystore, error) {
 
 	keystore.init(privateKey, publicKey)
 
-	// Handle system interrupt signals
-	keystore.catchSystemSignals()
-
 	return keystore, nil
 }
 
@@ -221,12 +212,6 @@ func (store *KeystoreImpl) init(privateKey []byte, publicKey *crypto.PublicKey)
 	return nil
 }
 
-func (store *KeystoreImpl) catchSystemSignals() {
-	HandleSignal(func() {
-		store.Lock()
-	})
-}
-
 func (store *KeystoreImpl) verifyPassword(password []byte) error {
 	passwordKey := crypto.ToAesKey(password)
 	defer ClearBytes(passwordKey)
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 
+	"github.com/elastos/Elastos.ELA.Client/config"
+
 	. "github.com/elastos/Elastos.ELA.Utility/common"
 )
 
@@ -27,7 +30,17 @@ type KeystoreFile struct {
 	PrivateKeyEncrypted string
 }
 
+// KeystorePath returns the path of the keystore file, a file name
+// without directory is in the directory of the selected wallet.
+func KeystorePath(name string) string {
+	if filepath.Base(name) != name {
+		return name
+	}
+	return filepath.Join(config.WalletDir(), name)
+}
+
 func CreateKeystoreFile(name string) (*KeystoreFile, error) {
+	name = KeystorePath(name)
 
 	if FileExisted(name) {
 		return nil, errors.New("key store file already exist")
@@ -44,7 +57,7 @@ func CreateKeystoreFile(name string) (*KeystoreFile, error) {
 func OpenKeystoreFile(name string) (*KeystoreFile, error) {
 
 	file := &KeystoreFile{
-		fileName: name,
+		fileName: KeystorePath(name),
 	}
 
 	err := file.LoadFromFile()
@@ -61,11 +74,12 @@ func OpenKeystoreFile(name string) (*KeystoreFile, error) {
 }
 
 func OpenFromOldVersion() (*KeystoreFile, error) {
-	if _, err := os.Stat(OldWalletFile); err != nil {
+	oldWalletFile := KeystorePath(OldWalletFile)
+	if _, err := os.Stat(oldWalletFile); err != nil {
 		return nil, errors.New("wallet file not exist")
 	}
 
-	file, err := os.OpenFile(OldWalletFile, os.O_RDONLY, 0666)
+	file, err := os.OpenFile(oldWalletFile, os.O_RDONLY, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -94,7 +108,7 @@ func OpenFromOldVersion() (*KeystoreFile, error) {
 	}
 
 	keystoreFile := &KeystoreFile{
-		fileName:            DefaultKeystoreFile,
+		fileName:            KeystorePath(DefaultKeystoreFile),
 		Version:             KeystoreVersion,
 		IV:                  content["IV"].(string),
 		PasswordHash:        content["PasswordHash"].(string),
@@ -196,6 +210,11 @@ func (store *KeystoreFile) SaveToFile() error {
 	store.Lock()
 	defer store.Unlock()
 
+	err := os.MkdirAll(filepath.Dir(store.fileName), 0700)
+	if err != nil {
+		return err
+	}
+
 	file, err := os.OpenFile(store.fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
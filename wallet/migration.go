@@ -0,0 +1,228 @@
+//go:build cgo
+// +build cgo
+
+package wallet
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/elastos/Elastos.ELA.Client/log"
+)
+
+const (
+	SchemaVersionKey = "SchemaVersion"
+	BackupFileFormat = "%s.v%d.bak"
+)
+
+// migrations upgrade the SQLite database schema in order, the schema version is the number of
+// migrations applied. Databases created before schema versions were recorded start from
+// version 0, so each migration must also work on a schema it was partly applied to.
+var migrations = []func(tx *sql.Tx) error{
+	// 1. Info, addresses and UTXOs tables
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(CreateInfoTable)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(CreateAddressesTable)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(CreateUTXOsTable)
+		if err != nil {
+			return err
+		}
+		sql := `INSERT INTO Info(Name, Value) SELECT ?,? WHERE NOT EXISTS(SELECT 1 FROM Info WHERE Name=?)`
+		_, err = tx.Exec(sql, "Height", uint32(0), "Height")
+		return err
+	},
+	// 2. Block hashes and undo log for chain reorganization
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(CreateBlocksTable)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(CreateUndoLogTable)
+		return err
+	},
+	// 3. Transaction history
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(CreateTransactionsTable)
+		if err != nil {
+			return err
+		}
+		return addColumn(tx, "Transactions", "Memo", "TEXT NOT NULL DEFAULT ''")
+	},
+	// 4. Indexes for block processing
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(CreateIndexes)
+		return err
+	},
+	// 5. Address birthday height
+	func(tx *sql.Tx) error {
+		return addColumn(tx, "Addresses", "Birthday", "INTEGER NOT NULL DEFAULT 0")
+	},
+	// 6. Height of the block each UTXO was created in
+	func(tx *sql.Tx) error {
+		err := addColumn(tx, "UTXOs", "Height", "INTEGER NOT NULL DEFAULT 0")
+		if err != nil {
+			return err
+		}
+		err = addColumn(tx, "UndoLog", "UTXOHeight", "INTEGER NOT NULL DEFAULT 0")
+		if err != nil {
+			return err
+		}
+		// Find the heights in transaction history, the OutPoint starts with the transaction ID
+		_, err = tx.Exec(`UPDATE UTXOs SET Height=IFNULL((SELECT Height FROM Transactions
+					WHERE TxID=lower(hex(substr(UTXOs.OutPoint, 1, 32))) LIMIT 1), 0)`)
+		return err
+	},
+	// 7. Frozen flag and label of UTXOs
+	func(tx *sql.Tx) error {
+		for _, column := range []struct{ table, name, definition string }{
+			{"UTXOs", "Frozen", "INTEGER NOT NULL DEFAULT 0"},
+			{"UTXOs", "Label", "TEXT NOT NULL DEFAULT ''"},
+			{"UndoLog", "UTXOFrozen", "INTEGER NOT NULL DEFAULT 0"},
+			{"UndoLog", "UTXOLabel", "TEXT NOT NULL DEFAULT ''"},
+		} {
+			err := addColumn(tx, column.table, column.name, column.definition)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 8. Spent UTXOs with the heights they were created and spent in
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(CreateSpentUTXOsTable)
+		return err
+	},
+	// 9. Rescan height of addresses, the existing ones have no blocks to replay
+	func(tx *sql.Tx) error {
+		return addColumn(tx, "Addresses", "RescanHeight", "INTEGER NOT NULL DEFAULT 4294967295")
+	},
+}
+
+// migrate upgrades the database schema to the latest version,
+// a backup of the database file is taken before an existing database is changed.
+func migrate(db *sql.DB, fileName string) error {
+	exist, err := tableExists(db, "Info")
+	if err != nil {
+		return err
+	}
+	version, err := schemaVersion(db, exist)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return errors.New(fmt.Sprint("[Wallet], Database version ", version, " is newer than supported version ",
+			len(migrations), ", please upgrade the client"))
+	}
+	if version == len(migrations) {
+		return nil
+	}
+
+	if exist {
+		backup := fmt.Sprintf(BackupFileFormat, fileName, version)
+		err = copyFile(fileName, backup)
+		if err != nil {
+			return errors.New("[Wallet], Backup database failed, " + err.Error())
+		}
+		log.Info("Database backup saved to", backup)
+	}
+
+	for version < len(migrations) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		err = migrations[version](tx)
+		if err != nil {
+			tx.Rollback()
+			return errors.New(fmt.Sprint("[Wallet], Migrate database to version ", version+1, " failed, ", err))
+		}
+		version++
+		_, err = tx.Exec("INSERT OR REPLACE INTO Info(Name, Value) values(?,?)", SchemaVersionKey, version)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func schemaVersion(db *sql.DB, infoExists bool) (int, error) {
+	if !infoExists {
+		return 0, nil
+	}
+	var version int
+	err := db.QueryRow("SELECT Value FROM Info WHERE Name=?", SchemaVersionKey).Scan(&version)
+	if err == sql.ErrNoRows {
+		// Created before schema versions were recorded
+		return 0, nil
+	}
+	return version, err
+}
+
+func tableExists(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid int
+		var name, colType string
+		var notNull, primaryKey int
+		var defaultValue interface{}
+		err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &primaryKey)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	rows.Close()
+
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,292 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+	"github.com/elastos/Elastos.ELA.Client/log"
+	. "github.com/elastos/Elastos.ELA.Client/rpc"
+)
+
+// Events found by synchronization
+const (
+	// A transaction paying to the wallet addresses
+	EventIncoming = "incoming"
+	// A transaction spending from the wallet addresses
+	EventOutgoing = "outgoing"
+	// A transaction reached the confirmations in the config
+	EventConfirmed = "confirmed"
+	// Synchronization reached a new block
+	EventBlock = "block"
+)
+
+const (
+	DefaultWebhookRetries = 3
+	WebhookTimeout        = 10 * time.Second
+	HookTimeout           = 30 * time.Second
+	// The delay before the first retry, it's doubled after each one
+	NotifyRetryDelay = time.Second
+	// The events waiting for each webhook or hook, synchronization waits when more are not delivered yet
+	NotifyQueueSize = 100
+)
+
+// NotifyEvent is the JSON body posted to webhooks and written to the stdin of hooks
+type NotifyEvent struct {
+	Event  string `json:"event"`
+	Wallet string `json:"wallet"`
+	// The height of the block the event is found in
+	Height        uint32             `json:"height"`
+	BlockHash     string             `json:"blockhash,omitempty"`
+	Confirmations uint32             `json:"confirmations,omitempty"`
+	Transaction   *NotifyTransaction `json:"transaction,omitempty"`
+}
+
+type NotifyTransaction struct {
+	TxID           string   `json:"txid"`
+	Address        string   `json:"address"`
+	Height         uint32   `json:"height"`
+	Time           uint32   `json:"time"`
+	Amount         string   `json:"amount"`
+	Fee            string   `json:"fee"`
+	Counterparties []string `json:"counterparties"`
+	Memo           string   `json:"memo"`
+}
+
+// Notifier delivers the events to the webhooks and hooks in the config in the background,
+// each of them has its own queue, so a slow one does not delay the others.
+type Notifier struct {
+	config    *config.NotifyConfig
+	client    *http.Client
+	endpoints []*notifyEndpoint
+	wait      sync.WaitGroup
+}
+
+// notifyEndpoint is a webhook or hook and the events queued for it
+type notifyEndpoint struct {
+	name    string
+	events  []string
+	deliver func(event string, body []byte) error
+	queue   chan *queuedEvent
+}
+
+type queuedEvent struct {
+	event string
+	body  []byte
+}
+
+// newNotifier starts a notifier of the config, it returns nil if no webhook or hook is configured.
+// The methods of a nil notifier do nothing.
+func newNotifier(cfg *config.NotifyConfig) *Notifier {
+	if len(cfg.Webhooks) == 0 && len(cfg.Hooks) == 0 {
+		return nil
+	}
+	notifier := &Notifier{
+		config: cfg,
+		client: &http.Client{Timeout: WebhookTimeout},
+	}
+	for i := range cfg.Webhooks {
+		webhook := &cfg.Webhooks[i]
+		notifier.start(webhook.URL, webhook.Events, func(event string, body []byte) error {
+			return notifier.post(webhook, event, body)
+		})
+	}
+	for i := range cfg.Hooks {
+		hook := &cfg.Hooks[i]
+		notifier.start(hook.Path, hook.Events, func(event string, body []byte) error {
+			return runHook(hook, event, body)
+		})
+	}
+	return notifier
+}
+
+// start delivers the events queued for an endpoint in the background
+func (notifier *Notifier) start(name string, events []string, deliver func(event string, body []byte) error) {
+	endpoint := &notifyEndpoint{
+		name:    name,
+		events:  events,
+		deliver: deliver,
+		queue:   make(chan *queuedEvent, NotifyQueueSize),
+	}
+	notifier.endpoints = append(notifier.endpoints, endpoint)
+	notifier.wait.Add(1)
+	go func() {
+		defer notifier.wait.Done()
+		for queued := range endpoint.queue {
+			err := endpoint.deliver(queued.event, queued.body)
+			if err != nil {
+				log.Error("Deliver", queued.event, "event to", endpoint.name, "failed, error:", err)
+			}
+		}
+	}()
+}
+
+// Subscribed returns if a webhook or hook is configured for event
+func (notifier *Notifier) Subscribed(event string) bool {
+	if notifier == nil {
+		return false
+	}
+	for _, endpoint := range notifier.endpoints {
+		if subscribed(endpoint.events, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify queues the event for the webhooks and hooks subscribing it,
+// it waits while the queue of one of them is full.
+func (notifier *Notifier) Notify(event *NotifyEvent) {
+	if notifier == nil || !notifier.Subscribed(event.Event) {
+		return
+	}
+	event.Wallet = config.WalletName()
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error("Encode event failed, error:", err)
+		return
+	}
+	for _, endpoint := range notifier.endpoints {
+		if subscribed(endpoint.events, event.Event) {
+			endpoint.queue <- &queuedEvent{event.Event, body}
+		}
+	}
+}
+
+// Close waits for the queued events to be delivered
+func (notifier *Notifier) Close() {
+	if notifier == nil {
+		return
+	}
+	for _, endpoint := range notifier.endpoints {
+		close(endpoint.queue)
+	}
+	notifier.wait.Wait()
+}
+
+// post sends the event to the webhook, and retries with exponential backoff if it's not accepted
+func (notifier *Notifier) post(webhook *config.WebhookConfig, event string, body []byte) error {
+	retries := webhook.Retries
+	if retries <= 0 {
+		retries = DefaultWebhookRetries
+	}
+	delay := NotifyRetryDelay
+	var err error
+	for i := 0; ; i++ {
+		err = notifier.postOnce(webhook, event, body)
+		if err == nil || i >= retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (notifier *Notifier) postOnce(webhook *config.WebhookConfig, event string, body []byte) error {
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-ELA-Event", event)
+	if webhook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(webhook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-ELA-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := notifier.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprint("response status ", resp.Status))
+	}
+	return nil
+}
+
+func runHook(hook *config.HookConfig, event string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), HookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hook.Path, event)
+	cmd.Stdin = bytes.NewReader(body)
+	output, err := cmd.CombinedOutput()
+	if err != nil && len(output) > 0 {
+		return errors.New(fmt.Sprint(err, ", output: ", string(output)))
+	}
+	return err
+}
+
+func subscribed(events []string, event string) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// newTxEvent creates the event of a transaction record, incoming or outgoing by its amount if event is empty
+func newTxEvent(event string, history *TxHistory, height uint32) *NotifyEvent {
+	if event == "" {
+		event = EventIncoming
+		if history.Amount < 0 {
+			event = EventOutgoing
+		}
+	}
+	return &NotifyEvent{
+		Event:         event,
+		Height:        height,
+		Confirmations: height - history.Height + 1,
+		Transaction: &NotifyTransaction{
+			TxID:           history.TxID,
+			Address:        history.Address,
+			Height:         history.Height,
+			Time:           history.Time,
+			Amount:         history.Amount.String(),
+			Fee:            history.Fee.String(),
+			Counterparties: history.Counterparties,
+			Memo:           history.Memo,
+		},
+	}
+}
+
+// notifyBlock fires the transaction events of the committed block, and the confirmed events
+// of the transactions reaching the confirmations in the config with it.
+// Nothing is fired for the blocks replayed below the notify height.
+func (sync *DataSyncImpl) notifyBlock(notifier *Notifier, block *BlockInfo, histories []*TxHistory) {
+	if block.Height < sync.notifyHeight {
+		return
+	}
+	for _, history := range histories {
+		notifier.Notify(newTxEvent("", history, block.Height))
+	}
+
+	confirmations := config.Params().Notify.Confirmations
+	if confirmations == 0 || block.Height+1 <= confirmations || !notifier.Subscribed(EventConfirmed) {
+		return
+	}
+	// Zero heights are ignored by the filter, transactions in the genesis block are not notified
+	confirmedHeight := block.Height + 1 - confirmations
+	confirmed, err := sync.GetTxHistory(&HistoryFilter{FromHeight: confirmedHeight, ToHeight: confirmedHeight})
+	if err != nil {
+		log.Error("Get transaction history failed at height:", confirmedHeight, "error:", err)
+		return
+	}
+	for _, history := range confirmed {
+		notifier.Notify(newTxEvent(EventConfirmed, history, block.Height))
+	}
+}
@@ -0,0 +1,175 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	"github.com/elastos/Elastos.ELA.Utility/crypto"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+const (
+	PartialTransactionVersion = "1.0"
+	PartialTransactionFileExt = ".ptxn"
+)
+
+// PartialTransaction is a JSON container of a transaction waiting for signatures,
+// it carries the context a signer needs to review the transaction before signing.
+type PartialTransaction struct {
+	Version     string            `json:"version"`
+	Transaction string            `json:"transaction"`
+	Inputs      []*PartialInput   `json:"inputs"`
+	Programs    []*PartialProgram `json:"programs"`
+	Notes       string            `json:"notes,omitempty"`
+}
+
+// PartialInput describes the output spent by a transaction input.
+type PartialInput struct {
+	TxID    string `json:"txid"`
+	Index   uint16 `json:"index"`
+	Address string `json:"address,omitempty"`
+	Amount  string `json:"amount,omitempty"`
+}
+
+// PartialProgram holds a redeem script and the signatures collected for it so far.
+type PartialProgram struct {
+	Address      string   `json:"address"`
+	RedeemScript string   `json:"redeemscript"`
+	Signatures   []string `json:"signatures"`
+	HaveSign     int      `json:"havesign"`
+	NeedSign     int      `json:"needsign"`
+}
+
+func NewPartialTransaction(txn *Transaction, notes string) (*PartialTransaction, error) {
+	ptxn := &PartialTransaction{
+		Version: PartialTransactionVersion,
+		Notes:   notes,
+	}
+	for _, input := range txn.Inputs {
+		ptxn.Inputs = append(ptxn.Inputs, &PartialInput{
+			TxID:  BytesToHexString(input.Previous.TxID.Bytes()),
+			Index: input.Previous.Index,
+		})
+	}
+	err := ptxn.Update(txn)
+	if err != nil {
+		return nil, err
+	}
+	return ptxn, nil
+}
+
+func ReadPartialTransaction(data []byte) (*PartialTransaction, error) {
+	var ptxn PartialTransaction
+	err := json.Unmarshal(data, &ptxn)
+	if err != nil {
+		return nil, err
+	}
+	if ptxn.Version != PartialTransactionVersion {
+		return nil, errors.New("unsupported partial transaction version " + ptxn.Version)
+	}
+	return &ptxn, nil
+}
+
+// Update refreshes the unsigned transaction and the collected signatures from txn.
+func (ptxn *PartialTransaction) Update(txn *Transaction) error {
+	buf := new(bytes.Buffer)
+	err := txn.SerializeUnsigned(buf)
+	if err != nil {
+		return err
+	}
+	ptxn.Transaction = BytesToHexString(buf.Bytes())
+
+	ptxn.Programs = nil
+	for _, program := range txn.Programs {
+		programHash, err := crypto.ToProgramHash(program.Code)
+		if err != nil {
+			return err
+		}
+		address, err := programHash.ToAddress()
+		if err != nil {
+			return err
+		}
+		signatures, err := splitSignatures(program.Parameter)
+		if err != nil {
+			return err
+		}
+		haveSign, needSign, err := crypto.GetSignStatus(program.Code, program.Parameter)
+		if err != nil {
+			return err
+		}
+		ptxn.Programs = append(ptxn.Programs, &PartialProgram{
+			Address:      address,
+			RedeemScript: BytesToHexString(program.Code),
+			Signatures:   signatures,
+			HaveSign:     haveSign,
+			NeedSign:     needSign,
+		})
+	}
+	return nil
+}
+
+// GetTransaction rebuilds the transaction with the signatures collected so far.
+func (ptxn *PartialTransaction) GetTransaction() (*Transaction, error) {
+	rawData, err := HexStringToBytes(ptxn.Transaction)
+	if err != nil {
+		return nil, errors.New("decode partial transaction content failed")
+	}
+	var txn Transaction
+	err = txn.DeserializeUnsigned(bytes.NewReader(rawData))
+	if err != nil {
+		return nil, errors.New("deserialize partial transaction failed")
+	}
+	for _, p := range ptxn.Programs {
+		code, err := HexStringToBytes(p.RedeemScript)
+		if err != nil {
+			return nil, errors.New("decode redeem script failed")
+		}
+		buf := new(bytes.Buffer)
+		for _, signature := range p.Signatures {
+			signatureBytes, err := HexStringToBytes(signature)
+			if err != nil {
+				return nil, errors.New("decode signature failed")
+			}
+			buf.WriteByte(byte(len(signatureBytes)))
+			buf.Write(signatureBytes)
+		}
+		txn.Programs = append(txn.Programs, &Program{Code: code, Parameter: buf.Bytes()})
+	}
+	return &txn, nil
+}
+
+// SignStatus returns the signatures collected and needed summed over all programs.
+func (ptxn *PartialTransaction) SignStatus() (haveSign, needSign int) {
+	for _, p := range ptxn.Programs {
+		haveSign += p.HaveSign
+		needSign += p.NeedSign
+	}
+	return haveSign, needSign
+}
+
+func splitSignatures(param []byte) ([]string, error) {
+	params, err := splitParameter(param)
+	if err != nil {
+		return nil, err
+	}
+	var signatures []string
+	for _, signature := range params {
+		signatures = append(signatures, BytesToHexString(signature))
+	}
+	return signatures, nil
+}
+
+func splitParameter(param []byte) ([][]byte, error) {
+	var signatures [][]byte
+	for len(param) > 0 {
+		length := int(param[0])
+		if len(param) < length+1 {
+			return nil, errors.New("invalid program parameter")
+		}
+		signatures = append(signatures, param[1:length+1])
+		param = param[length+1:]
+	}
+	return signatures, nil
+}
@@ -0,0 +1,121 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	"github.com/elastos/Elastos.ELA.Utility/crypto"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+// testMultiSign is a 2 of 3 multi sign account, the private keys are in the order of the public keys
+type testMultiSign struct {
+	code        []byte
+	programHash *Uint168
+	privateKeys [][]byte
+}
+
+func newTestMultiSign(t *testing.T) *testMultiSign {
+	t.Helper()
+	account := new(testMultiSign)
+	var publicKeys []*crypto.PublicKey
+	for i := 0; i < 3; i++ {
+		privateKey, publicKey, err := crypto.GenerateKeyPair()
+		mustSucceed(t, err)
+		account.privateKeys = append(account.privateKeys, privateKey)
+		publicKeys = append(publicKeys, publicKey)
+	}
+	code, err := crypto.CreateMultiSignRedeemScript(2, publicKeys)
+	mustSucceed(t, err)
+	account.code = code
+	// The redeem script sorts the public keys, find the private keys in its order
+	keys, err := getPublicKeys(code)
+	mustSucceed(t, err)
+	sorted := make([][]byte, len(keys))
+	for i, key := range keys {
+		encoded, err := key.EncodePoint(true)
+		mustSucceed(t, err)
+		for j, publicKey := range publicKeys {
+			point, err := publicKey.EncodePoint(true)
+			mustSucceed(t, err)
+			if bytes.Equal(encoded, point) {
+				sorted[i] = account.privateKeys[j]
+			}
+		}
+	}
+	account.privateKeys = sorted
+	account.programHash, err = crypto.ToProgramHash(code)
+	mustSucceed(t, err)
+	return account
+}
+
+// newTestTransaction spends testOutPoint1 of the account, paying amount to testProgramHash2
+func (account *testMultiSign) newTestTransaction(amount Fixed64) *Transaction {
+	return &Transaction{
+		TxType:     TransferAsset,
+		Payload:    &PayloadTransferAsset{},
+		Attributes: []*Attribute{},
+		Inputs:     []*Input{{Previous: *testOutPoint1}},
+		Outputs:    []*Output{{AssetID: SystemAssetId, Value: amount, ProgramHash: *testProgramHash2}},
+		Programs:   []*Program{{Code: account.code, Parameter: []byte{}}},
+	}
+}
+
+// sign returns a copy of txn with the signatures of the private keys at indexes appended to its program
+func (account *testMultiSign) sign(t *testing.T, txn *Transaction, indexes ...int) *Transaction {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	mustSucceed(t, txn.SerializeUnsigned(buf))
+	param := bytes.NewBuffer(append([]byte{}, txn.Programs[0].Parameter...))
+	for _, index := range indexes {
+		signature, err := crypto.Sign(account.privateKeys[index], buf.Bytes())
+		mustSucceed(t, err)
+		param.WriteByte(byte(len(signature)))
+		param.Write(signature)
+	}
+	signed := *txn
+	signed.Programs = []*Program{{Code: txn.Programs[0].Code, Parameter: param.Bytes()}}
+	return &signed
+}
+
+func TestPartialTransactionRoundTrip(t *testing.T) {
+	account := newTestMultiSign(t)
+	txn := account.sign(t, account.newTestTransaction(9), 1)
+
+	ptxn, err := NewPartialTransaction(txn, "rent")
+	mustSucceed(t, err)
+	if haveSign, needSign := ptxn.SignStatus(); haveSign != 1 || needSign != 2 {
+		t.Fatal("unexpected sign status", haveSign, needSign)
+	}
+	data, err := json.Marshal(ptxn)
+	mustSucceed(t, err)
+
+	read, err := ReadPartialTransaction(data)
+	mustSucceed(t, err)
+	if read.Notes != "rent" || len(read.Inputs) != 1 || len(read.Programs) != 1 {
+		t.Fatal("unexpected partial transaction", read)
+	}
+	restored, err := read.GetTransaction()
+	mustSucceed(t, err)
+	expected, actual := new(bytes.Buffer), new(bytes.Buffer)
+	mustSucceed(t, txn.Serialize(expected))
+	mustSucceed(t, restored.Serialize(actual))
+	if !bytes.Equal(expected.Bytes(), actual.Bytes()) {
+		t.Fatal("restored transaction is different")
+	}
+
+	// The next cosigner adds a signature to the restored transaction
+	mustSucceed(t, read.Update(account.sign(t, restored, 0)))
+	if haveSign, _ := read.SignStatus(); haveSign != 2 {
+		t.Fatal("signature not updated", haveSign)
+	}
+
+	ptxn.Version = "0.1"
+	data, err = json.Marshal(ptxn)
+	mustSucceed(t, err)
+	if _, err := ReadPartialTransaction(data); err == nil {
+		t.Fatal("read an unsupported version")
+	}
+}
@@ -0,0 +1,72 @@
+package wallet
+
+// Stages reported by the progress events
+const (
+	// Synchronizing the blocks after the wallet height
+	StageSync = "sync"
+	// Replaying the synchronized blocks for new addresses
+	StageRescan = "rescan"
+)
+
+// Kinds of the progress events
+const (
+	// A stage begins, Total is the number of blocks to process
+	ProgressStart = "start"
+	// A block is processed
+	ProgressBlock = "block"
+	// A stage ends, it's not reported if the stage fails
+	ProgressFinish = "finish"
+)
+
+// ProgressEvent reports the blocks processed by synchronization and rescan
+type ProgressEvent struct {
+	Event string `json:"event"`
+	Stage string `json:"stage"`
+	// The height of the last processed block, or the first block to process when the stage starts
+	Height uint32 `json:"height"`
+	// The number of the processed blocks and the blocks to process in the stage
+	Current int `json:"current"`
+	Total   int `json:"total"`
+}
+
+// ProgressHandler receives the progress events, it's called by the synchronizing goroutine
+// so it should return quickly.
+type ProgressHandler func(event *ProgressEvent)
+
+// progress counts the processed blocks of a stage and reports them to the handler
+type progress struct {
+	handler ProgressHandler
+	stage   string
+	height  uint32
+	current int
+	total   int
+}
+
+func startProgress(handler ProgressHandler, stage string, fromHeight, toHeight uint32) *progress {
+	p := &progress{handler: handler, stage: stage, height: fromHeight, total: int(toHeight - fromHeight + 1)}
+	p.report(ProgressStart)
+	return p
+}
+
+func (p *progress) increment(height uint32) {
+	p.height = height
+	p.current++
+	p.report(ProgressBlock)
+}
+
+func (p *progress) finish() {
+	p.report(ProgressFinish)
+}
+
+func (p *progress) report(event string) {
+	if p.handler == nil {
+		return
+	}
+	p.handler(&ProgressEvent{
+		Event:   event,
+		Stage:   p.stage,
+		Height:  p.height,
+		Current: p.current,
+		Total:   p.total,
+	})
+}
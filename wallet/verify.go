@@ -0,0 +1,214 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/elastos/Elastos.ELA.Client/config"
+	. "github.com/elastos/Elastos.ELA.Client/rpc"
+
+	. "github.com/elastos/Elastos.ELA.Utility/common"
+	"github.com/elastos/Elastos.ELA.Utility/crypto"
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+const (
+	MaxTransactionSize = 8000000
+)
+
+// referOutput is the output referenced by a transaction input
+type referOutput struct {
+	ProgramHash *Uint168
+	Amount      Fixed64
+	LockTime    uint32
+}
+
+// VerifyTransaction checks a transaction locally before it is sent to the node,
+// the spent outputs are looked up in the local data store first, then on the node.
+func (wallet *WalletImpl) VerifyTransaction(txn *Transaction) error {
+	// Check transaction size
+	buf := new(bytes.Buffer)
+	txn.Serialize(buf)
+	if buf.Len() > MaxTransactionSize {
+		return errors.New(fmt.Sprint("[Wallet], Transaction size ", buf.Len(), " exceeds limit ", MaxTransactionSize))
+	}
+
+	if len(txn.Inputs) == 0 {
+		return errors.New("[Wallet], Transaction has no input")
+	}
+
+	// Check transaction lock height
+	currentHeight := wallet.CurrentHeight(QueryHeightCode)
+	if txn.LockTime >= currentHeight {
+		return errors.New(fmt.Sprint("[Wallet], Transaction locked until height ", txn.LockTime))
+	}
+
+	// Check inputs
+	var totalInput Fixed64
+	signers := make(map[Uint168]bool)
+	spent := make(map[OutPoint]bool)
+	for _, input := range txn.Inputs {
+		op := input.Previous
+		if spent[op] {
+			return errors.New(fmt.Sprint("[Wallet], Duplicate input ", BytesToHexString(op.TxID.Bytes()), ":", op.Index))
+		}
+		spent[op] = true
+
+		refer, err := wallet.getReferOutput(&op)
+		if err != nil {
+			return err
+		}
+		if refer.LockTime >= currentHeight {
+			return errors.New(fmt.Sprint("[Wallet], Input ", BytesToHexString(op.TxID.Bytes()), ":", op.Index,
+				" locked until height ", refer.LockTime))
+		}
+		totalInput += refer.Amount
+		signers[*refer.ProgramHash] = true
+	}
+
+	// Check outputs
+	var totalOutput Fixed64
+	for _, output := range txn.Outputs {
+		if !output.AssetID.IsEqual(SystemAssetId) {
+			return errors.New("[Wallet], Invalid output asset, only ELA can be transferred")
+		}
+		if output.Value < 0 {
+			return errors.New("[Wallet], Invalid output amount")
+		}
+		totalOutput += output.Value
+	}
+	if totalOutput > totalInput {
+		return errors.New(fmt.Sprint("[Wallet], Outputs amount ", totalOutput.String(),
+			" exceeds inputs amount ", totalInput.String()))
+	}
+
+	// Check signatures
+	buf = new(bytes.Buffer)
+	txn.SerializeUnsigned(buf)
+	data := buf.Bytes()
+	for i, program := range txn.Programs {
+		programHash, err := crypto.ToProgramHash(program.Code)
+		if err != nil {
+			return err
+		}
+		if !signers[*programHash] {
+			return errors.New(fmt.Sprint("[Wallet], Program ", i, " does not match any input"))
+		}
+		delete(signers, *programHash)
+
+		err = verifySignatures(program, data)
+		if err != nil {
+			return errors.New(fmt.Sprint("[Wallet], Program ", i, " ", err))
+		}
+	}
+	if len(signers) > 0 {
+		return errors.New("[Wallet], Missing program for inputs")
+	}
+
+	return nil
+}
+
+func (wallet *WalletImpl) getReferOutput(op *OutPoint) (*referOutput, error) {
+	// Look up local data store first
+	programHash, utxo, err := wallet.GetUTXO(op)
+	if err == nil {
+		return &referOutput{programHash, *utxo.Amount, utxo.LockTime}, nil
+	}
+
+	// Look up the node
+	txID := BytesToHexString(op.TxID.Bytes())
+	txInfo, err := GetTransaction(txID)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("[Wallet], Input ", txID, ":", op.Index, " not found"))
+	}
+	if int(op.Index) >= len(txInfo.Outputs) {
+		return nil, errors.New(fmt.Sprint("[Wallet], Input ", txID, ":", op.Index, " not found"))
+	}
+	output := txInfo.Outputs[op.Index]
+	if output.AssetID != BytesToHexString(SystemAssetId.Bytes()) {
+		return nil, errors.New(fmt.Sprint("[Wallet], Input ", txID, ":", op.Index, " is not ELA asset"))
+	}
+
+	// Check if referenced output is unspent
+	utxos, err := ListUnspent(output.Address)
+	if err != nil {
+		return nil, err
+	}
+	var unspent bool
+	for _, u := range utxos {
+		if u.TxID == txID && u.VOut == uint32(op.Index) {
+			unspent = true
+			break
+		}
+	}
+	if !unspent {
+		return nil, errors.New(fmt.Sprint("[Wallet], Input ", txID, ":", op.Index, " already spent"))
+	}
+
+	programHash, err = Uint168FromAddress(output.Address)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := StringToFixed64(output.Value)
+	if err != nil {
+		return nil, err
+	}
+	lockTime := output.OutputLock
+	if txInfo.TxType == CoinBase {
+		height, err := getBlockHeight(txInfo.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		lockTime = height + config.CoinbaseMaturity
+	}
+	return &referOutput{programHash, *amount, lockTime}, nil
+}
+
+// getBlockHeight returns the height of the block a transaction is packed in
+func getBlockHeight(blockHash string) (uint32, error) {
+	hashBytes, err := HexStringToBytes(blockHash)
+	if err != nil {
+		return 0, errors.New(fmt.Sprint("[Wallet], Invalid block hash ", blockHash))
+	}
+	hash, err := Uint256FromBytes(hashBytes)
+	if err != nil {
+		return 0, errors.New(fmt.Sprint("[Wallet], Invalid block hash ", blockHash))
+	}
+	block, err := GetBlock(hash)
+	if err != nil {
+		return 0, err
+	}
+	return block.Height, nil
+}
+
+func verifySignatures(program *Program, data []byte) error {
+	publicKeys, err := getPublicKeys(program.Code)
+	if err != nil {
+		return err
+	}
+	_, needSign, err := crypto.GetSignStatus(program.Code, nil)
+	if err != nil {
+		return err
+	}
+	signatures, err := splitParameter(program.Parameter)
+	if err != nil {
+		return err
+	}
+
+	signed := make(map[int]bool)
+	for _, signature := range signatures {
+		index, err := matchSignature(publicKeys, data, signature)
+		if err != nil {
+			return errors.New("has invalid signature")
+		}
+		if signed[index] {
+			return errors.New("has duplicate signature")
+		}
+		signed[index] = true
+	}
+	if len(signed) < needSign {
+		return errors.New(fmt.Sprint("not fully signed [", len(signed), "/", needSign, "]"))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package wallet
+
+import (
+	"testing"
+
+	. "github.com/elastos/Elastos.ELA/core"
+)
+
+func TestVerifyTransaction(t *testing.T) {
+	account := newTestMultiSign(t)
+	other := newTestMultiSign(t)
+	store := NewMemoryDataStore()
+	mustSucceed(t, store.AddAddress(account.programHash, account.code, TypeMulti, 0))
+	mustSucceed(t, store.BeginBlock())
+	mustSucceed(t, store.AddAddressUTXO(account.programHash, testUTXO(testOutPoint1, 10, 0), 0))
+	mustSucceed(t, store.CommitBlock(0, "hash0"))
+	wallet := &WalletImpl{DataStore: store}
+
+	tests := []struct {
+		name  string
+		txn   func() *Transaction
+		valid bool
+	}{
+		{"Signed", func() *Transaction {
+			return account.sign(t, account.newTestTransaction(9), 0, 1)
+		}, true},
+		{"PartiallySigned", func() *Transaction {
+			return account.sign(t, account.newTestTransaction(9), 2)
+		}, false},
+		{"DuplicateSignature", func() *Transaction {
+			return account.sign(t, account.newTestTransaction(9), 1, 1)
+		}, false},
+		{"OutputsExceedInputs", func() *Transaction {
+			return account.sign(t, account.newTestTransaction(11), 0, 1)
+		}, false},
+		{"OtherAsset", func() *Transaction {
+			txn := account.newTestTransaction(9)
+			txn.Outputs[0].AssetID[0]++
+			return account.sign(t, txn, 0, 1)
+		}, false},
+		{"DuplicateInput", func() *Transaction {
+			txn := account.newTestTransaction(9)
+			txn.Inputs = append(txn.Inputs, txn.Inputs[0])
+			return account.sign(t, txn, 0, 1)
+		}, false},
+		{"Locked", func() *Transaction {
+			txn := account.newTestTransaction(9)
+			txn.LockTime = 5
+			return account.sign(t, txn, 0, 1)
+		}, false},
+		{"OtherSigner", func() *Transaction {
+			txn := account.newTestTransaction(9)
+			txn.Programs[0].Code = other.code
+			return other.sign(t, txn, 0, 1)
+		}, false},
+		{"MissingProgram", func() *Transaction {
+			txn := account.newTestTransaction(9)
+			txn.Programs = nil
+			return txn
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := wallet.VerifyTransaction(test.txn())
+			if test.valid && err != nil {
+				t.Fatal("valid transaction refused", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("invalid transaction accepted")
+			}
+		})
+	}
+}
@@ -2,12 +2,13 @@ package wallet
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"math"
 	"math/rand"
 	"strconv"
 
+	"github.com/elastos/Elastos.ELA.Client/config"
 	"github.com/elastos/Elastos.ELA.Client/log"
 
 	. "github.com/elastos/Elastos.ELA.Utility/common"
@@ -28,15 +29,26 @@ type Wallet interface {
 	Open(name string, password []byte) error
 	ChangePassword(oldPassword, newPassword []byte) error
 
-	AddStandardAccount(publicKey *crypto.PublicKey) (*Uint168, error)
-	AddMultiSignAccount(M uint, publicKey ...*crypto.PublicKey) (*Uint168, error)
+	IsDataLocked() bool
+	UnlockData(password []byte) error
+	EncryptDataStore(password []byte) error
+	CreateBackup(keystore string, fileName string, password []byte) (*Backup, error)
+	Check(ctx context.Context, repair bool) (*CheckReport, error)
+
+	AddStandardAccount(publicKey *crypto.PublicKey, birthday uint32) (*Uint168, error)
+	AddMultiSignAccount(M uint, birthday uint32, publicKey ...*crypto.PublicKey) (*Uint168, error)
 
 	CreateTransaction(fromAddress, toAddress string, amount, fee *Fixed64) (*Transaction, error)
 	CreateLockedTransaction(fromAddress, toAddress string, amount, fee *Fixed64, lockedUntil uint32) (*Transaction, error)
 	CreateMultiOutputTransaction(fromAddress string, fee *Fixed64, output ...*Transfer) (*Transaction, error)
 	CreateLockedMultiOutputTransaction(fromAddress string, fee *Fixed64, lockedUntil uint32, output ...*Transfer) (*Transaction, error)
+	CreateCoinControlTransaction(fromAddress string, fee *Fixed64, lockedUntil uint32, utxos []*OutPoint, output ...*Transfer) (*Transaction, error)
+
+	ToPartialTransaction(txn *Transaction, notes string) (*PartialTransaction, error)
+	CheckPartialTransaction(ptxn *PartialTransaction, txn *Transaction) error
 
 	Sign(name string, password []byte, transaction *Transaction) (*Transaction, error)
+	VerifyTransaction(transaction *Transaction) error
 
 	Reset() error
 }
@@ -59,7 +71,9 @@ func Create(name string, password []byte) (*WalletImpl, error) {
 		return nil, err
 	}
 
-	dataStore.AddAddress(keyStore.GetProgramHash(), keyStore.GetRedeemScript(), TypeMaster)
+	// A new master account has no transactions before current height
+	birthday := dataStore.CurrentHeight(QueryHeightCode)
+	dataStore.AddAddress(keyStore.GetProgramHash(), keyStore.GetRedeemScript(), TypeMaster, birthday)
 
 	return &WalletImpl{
 		DataStore: dataStore,
@@ -87,7 +101,68 @@ func (wallet *WalletImpl) Open(name string, password []byte) error {
 	return nil
 }
 
-func (wallet *WalletImpl) AddStandardAccount(publicKey *crypto.PublicKey) (*Uint168, error) {
+// ChangePassword changes the password of the keystore, and the key of the data if it's encrypted
+func (wallet *WalletImpl) ChangePassword(oldPassword, newPassword []byte) error {
+	store, encrypted := wallet.DataStore.(*EncryptedDataStore)
+	if encrypted && store.IsDataLocked() {
+		err := store.UnlockData(oldPassword)
+		if err != nil {
+			return err
+		}
+	}
+	if !encrypted {
+		return wallet.Keystore.ChangePassword(oldPassword, newPassword)
+	}
+	// The data is replaced after the keystore password is changed
+	var keystoreChanged bool
+	err := store.ChangePassword(newPassword, func() error {
+		err := wallet.Keystore.ChangePassword(oldPassword, newPassword)
+		keystoreChanged = err == nil
+		return err
+	})
+	if err != nil && keystoreChanged {
+		return errors.New("[Wallet], Keystore password changed but the data is still encrypted with the old one, " +
+			err.Error())
+	}
+	return err
+}
+
+// IsDataLocked returns if the data is encrypted and not unlocked yet
+func (wallet *WalletImpl) IsDataLocked() bool {
+	store, ok := wallet.DataStore.(*EncryptedDataStore)
+	return ok && store.IsDataLocked()
+}
+
+// UnlockData decrypts the data with the wallet password, it does nothing if the data is not encrypted
+func (wallet *WalletImpl) UnlockData(password []byte) error {
+	store, ok := wallet.DataStore.(*EncryptedDataStore)
+	if !ok {
+		return nil
+	}
+	return store.UnlockData(password)
+}
+
+// EncryptDataStore moves the data to an encrypted data store, the plaintext database files are removed
+func (wallet *WalletImpl) EncryptDataStore(password []byte) error {
+	store, err := EncryptDataStore(wallet.DataStore, password)
+	if err != nil {
+		return err
+	}
+	wallet.DataStore = store
+	return nil
+}
+
+// CreateBackup writes the keystores and the data of this wallet to an archive file, see CreateBackup
+func (wallet *WalletImpl) CreateBackup(keystore string, fileName string, password []byte) (*Backup, error) {
+	return CreateBackup(wallet.DataStore, keystore, fileName, password)
+}
+
+// Check checks the data of this wallet against the node, see CheckWallet
+func (wallet *WalletImpl) Check(ctx context.Context, repair bool) (*CheckReport, error) {
+	return CheckWallet(ctx, wallet.DataStore, repair)
+}
+
+func (wallet *WalletImpl) AddStandardAccount(publicKey *crypto.PublicKey, birthday uint32) (*Uint168, error) {
 	redeemScript, err := crypto.CreateStandardRedeemScript(publicKey)
 	if err != nil {
 		return nil, errors.New("[Wallet], CreateStandardRedeemScript failed")
@@ -98,7 +173,7 @@ func (wallet *WalletImpl) AddStandardAccount(publicKey *crypto.PublicKey) (*Uint
 		return nil, errors.New("[Wallet], CreateStandardAddress failed")
 	}
 
-	err = wallet.AddAddress(programHash, redeemScript, TypeStand)
+	err = wallet.addAccountAddress(programHash, redeemScript, TypeStand, birthday)
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +181,7 @@ func (wallet *WalletImpl) AddStandardAccount(publicKey *crypto.PublicKey) (*Uint
 	return programHash, nil
 }
 
-func (wallet *WalletImpl) AddMultiSignAccount(M uint, publicKeys ...*crypto.PublicKey) (*Uint168, error) {
+func (wallet *WalletImpl) AddMultiSignAccount(M uint, birthday uint32, publicKeys ...*crypto.PublicKey) (*Uint168, error) {
 	redeemScript, err := crypto.CreateMultiSignRedeemScript(M, publicKeys)
 	if err != nil {
 		return nil, errors.New("[Wallet], CreateStandardRedeemScript failed")
@@ -117,7 +192,7 @@ func (wallet *WalletImpl) AddMultiSignAccount(M uint, publicKeys ...*crypto.Publ
 		return nil, errors.New("[Wallet], CreateMultiSignAddress failed")
 	}
 
-	err = wallet.AddAddress(programHash, redeemScript, TypeMulti)
+	err = wallet.addAccountAddress(programHash, redeemScript, TypeMulti, birthday)
 	if err != nil {
 		return nil, err
 	}
@@ -125,6 +200,18 @@ func (wallet *WalletImpl) AddMultiSignAccount(M uint, publicKeys ...*crypto.Publ
 	return programHash, nil
 }
 
+// addAccountAddress adds the address of an account, the synchronized blocks from its birthday are replayed by Rescan
+func (wallet *WalletImpl) addAccountAddress(programHash *Uint168, redeemScript []byte, addrType int, birthday uint32) error {
+	err := wallet.AddAddress(programHash, redeemScript, addrType, birthday)
+	if err != nil {
+		return err
+	}
+	if birthday >= wallet.CurrentHeight(QueryHeightCode) {
+		return nil
+	}
+	return wallet.SetRescanHeight(programHash, birthday)
+}
+
 func (wallet *WalletImpl) CreateTransaction(fromAddress, toAddress string, amount, fee *Fixed64) (*Transaction, error) {
 	return wallet.CreateLockedTransaction(fromAddress, toAddress, amount, fee, uint32(0))
 }
@@ -138,16 +225,25 @@ func (wallet *WalletImpl) CreateMultiOutputTransaction(fromAddress string, fee *
 }
 
 func (wallet *WalletImpl) CreateLockedMultiOutputTransaction(fromAddress string, fee *Fixed64, lockedUntil uint32, outputs ...*Transfer) (*Transaction, error) {
-	return wallet.createTransaction(fromAddress, fee, lockedUntil, outputs...)
+	return wallet.createTransaction(fromAddress, fee, lockedUntil, nil, outputs...)
 }
 
-func (wallet *WalletImpl) createTransaction(fromAddress string, fee *Fixed64, lockedUntil uint32, outputs ...*Transfer) (*Transaction, error) {
+// CreateCoinControlTransaction spends exactly the given UTXOs of the spender, including frozen ones,
+// the change goes back to the spender.
+func (wallet *WalletImpl) CreateCoinControlTransaction(fromAddress string, fee *Fixed64, lockedUntil uint32, utxos []*OutPoint, outputs ...*Transfer) (*Transaction, error) {
+	if len(utxos) == 0 {
+		return nil, errors.New("[Wallet], No UTXO selected")
+	}
+	return wallet.createTransaction(fromAddress, fee, lockedUntil, utxos, outputs...)
+}
+
+// createTransaction spends the selected UTXOs, or selects them automatically when selected is empty,
+// the wallet is expected to be synchronized by SyncChainData before.
+func (wallet *WalletImpl) createTransaction(fromAddress string, fee *Fixed64, lockedUntil uint32, selected []*OutPoint, outputs ...*Transfer) (*Transaction, error) {
 	// Check if output is valid
 	if outputs == nil || len(outputs) == 0 {
 		return nil, errors.New("[Wallet], Invalid transaction target")
 	}
-	// Sync chain block data before create transaction
-	wallet.SyncChainData()
 
 	// Check if from address is valid
 	spender, err := Uint168FromAddress(fromAddress)
@@ -164,6 +260,9 @@ func (wallet *WalletImpl) createTransaction(fromAddress string, fee *Fixed64, lo
 		if err != nil {
 			return nil, errors.New(fmt.Sprint("[Wallet], Invalid receiver address: ", output.Address, ", error: ", err))
 		}
+		if !config.IsValidPrefix(receiver[0]) {
+			return nil, errors.New(fmt.Sprint("[Wallet], Invalid receiver address prefix: ", output.Address))
+		}
 		txOutput := &Output{
 			AssetID:     SystemAssetId,
 			ProgramHash: *receiver,
@@ -173,25 +272,47 @@ func (wallet *WalletImpl) createTransaction(fromAddress string, fee *Fixed64, lo
 		totalOutputAmount += *output.Amount
 		txOutputs = append(txOutputs, txOutput)
 	}
-	// Get spender's UTXOs
-	UTXOs, err := wallet.GetAddressUTXOs(spender)
-	if err != nil {
-		return nil, errors.New("[Wallet], Get spender's UTXOs failed")
-	}
-	availableUTXOs := wallet.removeLockedUTXOs(UTXOs) // Remove locked UTXOs
-	availableUTXOs = SortUTXOs(availableUTXOs)        // Sort available UTXOs by value ASC
-
 	// Create transaction inputs
 	var txInputs []*Input // The inputs in transaction
-	for _, utxo := range availableUTXOs {
-		input := &Input{
-			Previous: OutPoint{
-				TxID:  utxo.Op.TxID,
-				Index: utxo.Op.Index,
-			},
-			Sequence: utxo.LockTime,
+	if len(selected) > 0 {
+		selectedUTXOs, err := wallet.getSelectedUTXOs(spender, selected)
+		if err != nil {
+			return nil, err
+		}
+		// Spend all selected UTXOs
+		var totalInputAmount = Fixed64(0)
+		for _, utxo := range selectedUTXOs {
+			txInputs = append(txInputs, newInput(utxo))
+			totalInputAmount += *utxo.Amount
+		}
+		if totalInputAmount < totalOutputAmount {
+			return nil, errors.New("[Wallet], Selected UTXOs are not enough")
 		}
-		txInputs = append(txInputs, input)
+		if totalInputAmount > totalOutputAmount {
+			change := &Output{
+				AssetID:     SystemAssetId,
+				Value:       totalInputAmount - totalOutputAmount,
+				OutputLock:  uint32(0),
+				ProgramHash: *spender,
+			}
+			txOutputs = append(txOutputs, change)
+		}
+		totalOutputAmount = 0
+	}
+
+	// Get spender's UTXOs
+	var availableUTXOs []*UTXO
+	if len(selected) == 0 {
+		UTXOs, err := wallet.GetAddressUTXOs(spender)
+		if err != nil {
+			return nil, errors.New("[Wallet], Get spender's UTXOs failed")
+		}
+		availableUTXOs = wallet.removeLockedUTXOs(UTXOs)   // Remove locked UTXOs
+		availableUTXOs = removeFrozenUTXOs(availableUTXOs) // Remove frozen UTXOs
+		availableUTXOs = SortUTXOs(availableUTXOs)         // Sort available UTXOs by value ASC
+	}
+	for _, utxo := range availableUTXOs {
+		txInputs = append(txInputs, newInput(utxo))
 		if *utxo.Amount < totalOutputAmount {
 			totalOutputAmount -= *utxo.Amount
 		} else if *utxo.Amount == totalOutputAmount {
@@ -221,69 +342,148 @@ func (wallet *WalletImpl) createTransaction(fromAddress string, fee *Fixed64, lo
 	return wallet.newTransaction(account.RedeemScript, txInputs, txOutputs), nil
 }
 
+func (wallet *WalletImpl) ToPartialTransaction(txn *Transaction, notes string) (*PartialTransaction, error) {
+	ptxn, err := NewPartialTransaction(txn, notes)
+	if err != nil {
+		return nil, err
+	}
+	// Fill in the spent outputs known by the local data store
+	for i, input := range txn.Inputs {
+		programHash, utxo, err := wallet.GetUTXO(&input.Previous)
+		if err != nil {
+			continue
+		}
+		address, err := programHash.ToAddress()
+		if err != nil {
+			return nil, err
+		}
+		ptxn.Inputs[i].Address = address
+		ptxn.Inputs[i].Amount = utxo.Amount.String()
+	}
+	return ptxn, nil
+}
+
+// CheckPartialTransaction checks the inputs described by a partial transaction file against the transaction
+// and the outputs they spend, which are looked up in the local data store first, then on the node.
+// The addresses and amounts a signer reviews are replaced by the ones found, they are not trusted from the file.
+func (wallet *WalletImpl) CheckPartialTransaction(ptxn *PartialTransaction, txn *Transaction) error {
+	if len(ptxn.Inputs) != len(txn.Inputs) {
+		return errors.New("[Wallet], Partial transaction inputs do not match the transaction")
+	}
+	for i, input := range txn.Inputs {
+		op := input.Previous
+		partial := ptxn.Inputs[i]
+		if partial.TxID != BytesToHexString(op.TxID.Bytes()) || partial.Index != op.Index {
+			return errors.New(fmt.Sprint("[Wallet], Partial transaction input ", i, " does not match the transaction"))
+		}
+		refer, err := wallet.getReferOutput(&op)
+		if err != nil {
+			return err
+		}
+		address, err := refer.ProgramHash.ToAddress()
+		if err != nil {
+			return err
+		}
+		if partial.Address != "" && partial.Address != address {
+			return errors.New(fmt.Sprint("[Wallet], Input ", FormatOutPoint(&op), " is owned by ", address,
+				", not ", partial.Address))
+		}
+		if partial.Amount != "" {
+			amount, err := StringToFixed64(partial.Amount)
+			if err != nil || *amount != refer.Amount {
+				return errors.New(fmt.Sprint("[Wallet], Input ", FormatOutPoint(&op), " amount is ",
+					refer.Amount.String(), ", not ", partial.Amount))
+			}
+		}
+		partial.Address = address
+		partial.Amount = refer.Amount.String()
+	}
+	return nil
+}
+
 func (wallet *WalletImpl) Sign(name string, password []byte, txn *Transaction) (*Transaction, error) {
 	// Verify password
 	err := wallet.Open(name, password)
 	if err != nil {
 		return nil, err
 	}
-	// Get sign type
-	signType, err := crypto.GetScriptType(txn.Programs[0].Code)
+	// Sign transaction
+	signature, err := wallet.Keystore.Sign(txn)
 	if err != nil {
 		return nil, err
 	}
-	// Look up transaction type
-	if signType == STANDARD {
-
-		// Sign single transaction
-		txn, err = wallet.signStandardTransaction(txn)
+	// Walk through programs, sign the ones current user is a valid signer of
+	var signed bool
+	for _, program := range txn.Programs {
+		// Get sign type
+		signType, err := crypto.GetScriptType(program.Code)
 		if err != nil {
-			return nil, err
+			// Not a program this wallet can sign, leave it to the other signers
+			continue
 		}
+		var ok bool
+		// Look up program type
+		if signType == STANDARD {
+
+			// Sign standard program
+			ok, err = wallet.signStandardProgram(program, signature)
+			if err != nil {
+				return nil, err
+			}
 
-	} else if signType == MULTISIG {
+		} else if signType == MULTISIG {
 
-		// Sign multi sign transaction
-		txn, err = wallet.signMultiSignTransaction(txn)
-		if err != nil {
-			return nil, err
+			// Sign multi sign program
+			ok, err = wallet.signMultiSignProgram(txn, program, signature)
+			if err != nil {
+				return nil, err
+			}
 		}
+		signed = signed || ok
+	}
+	if !signed {
+		return nil, errors.New("[Wallet], Invalid signer")
 	}
 
 	return txn, nil
 }
 
-func (wallet *WalletImpl) signStandardTransaction(txn *Transaction) (*Transaction, error) {
-	code := txn.Programs[0].Code
+func (wallet *WalletImpl) signStandardProgram(program *Program, signature []byte) (bool, error) {
 	// Get signer
-	programHash, err := crypto.GetSigner(code)
+	programHash, err := crypto.GetSigner(program.Code)
+	if err != nil {
+		return false, err
+	}
 	// Check if current user is a valid signer
 	if *programHash != *wallet.Keystore.GetProgramHash() {
-		return nil, errors.New("[Wallet], Invalid signer")
-	}
-	// Sign transaction
-	signedTx, err := wallet.Keystore.Sign(txn)
-	if err != nil {
-		return nil, err
+		return false, nil
 	}
 	// Add verify program for transaction
 	buf := new(bytes.Buffer)
-	buf.WriteByte(byte(len(signedTx)))
-	buf.Write(signedTx)
+	buf.WriteByte(byte(len(signature)))
+	buf.Write(signature)
 	// Add signature
-	txn.Programs[0].Parameter = buf.Bytes()
+	program.Parameter = buf.Bytes()
 
-	return txn, nil
+	return true, nil
 }
 
-func (wallet *WalletImpl) signMultiSignTransaction(txn *Transaction) (*Transaction, error) {
-	code := txn.Programs[0].Code
-	param := txn.Programs[0].Parameter
+func (wallet *WalletImpl) signMultiSignProgram(txn *Transaction, program *Program, signature []byte) (bool, error) {
+	code := program.Code
+	param := program.Parameter
+	// Check if program was fully signed
+	haveSign, needSign, err := crypto.GetSignStatus(code, param)
+	if err != nil {
+		return false, err
+	}
+	if haveSign == needSign {
+		return false, nil
+	}
 	// Check if current user is a valid signer
 	var signerIndex = -1
 	programHashes, err := crypto.GetSigners(code)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 	userProgramHash := wallet.Keystore.GetProgramHash()
 	for i, programHash := range programHashes {
@@ -293,22 +493,28 @@ func (wallet *WalletImpl) signMultiSignTransaction(txn *Transaction) (*Transacti
 		}
 	}
 	if signerIndex == -1 {
-		return nil, errors.New("[Wallet], Invalid multi sign signer")
+		return false, nil
 	}
-	// Sign transaction
-	signature, err := wallet.Keystore.Sign(txn)
+	// Check if current user has signed already
+	buf := new(bytes.Buffer)
+	txn.SerializeUnsigned(buf)
+	signatures, err := splitParameter(param)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	publicKey := wallet.Keystore.GetPublicKey()
+	for _, s := range signatures {
+		if crypto.Verify(*publicKey, buf.Bytes(), s) == nil {
+			return false, nil
+		}
 	}
 	// Append signature
-	buf := new(bytes.Buffer)
-	txn.SerializeUnsigned(buf)
-	txn.Programs[0].Parameter, err = crypto.AppendSignature(signerIndex, signature, buf.Bytes(), code, param)
+	program.Parameter, err = crypto.AppendSignature(signerIndex, signature, buf.Bytes(), code, param)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	return txn, nil
+	return true, nil
 }
 
 func (wallet *WalletImpl) Reset() error {
@@ -336,15 +542,68 @@ func getSystemAssetId() Uint256 {
 	return systemToken.Hash()
 }
 
+// getSelectedUTXOs finds the selected UTXOs, they must belong to spender and be spendable,
+// frozen UTXOs are allowed as they are selected explicitly
+func (wallet *WalletImpl) getSelectedUTXOs(spender *Uint168, selected []*OutPoint) ([]*UTXO, error) {
+	var utxos []*UTXO
+	var currentHeight = wallet.CurrentHeight(QueryHeightCode)
+	var added = make(map[OutPoint]bool)
+	for _, op := range selected {
+		name := fmt.Sprint(BytesToHexString(op.TxID.Bytes()), ":", op.Index)
+		if added[*op] {
+			return nil, errors.New("[Wallet], UTXO " + name + " is selected more than once")
+		}
+		owner, utxo, err := wallet.GetUTXO(op)
+		if err == ErrNotFound {
+			return nil, errors.New("[Wallet], UTXO " + name + " not found in wallet")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !owner.IsEqual(*spender) {
+			return nil, errors.New("[Wallet], UTXO " + name + " does not belong to the spender")
+		}
+		if utxo.LockTime >= currentHeight {
+			return nil, errors.New(fmt.Sprint("[Wallet], UTXO ", name, " is locked until height ", utxo.LockTime))
+		}
+		added[*op] = true
+		utxos = append(utxos, utxo)
+	}
+	return utxos, nil
+}
+
+// newInput creates a transaction input spending utxo
+func newInput(utxo *UTXO) *Input {
+	input := &Input{
+		Previous: OutPoint{
+			TxID:  utxo.Op.TxID,
+			Index: utxo.Op.Index,
+		},
+	}
+	// Locked output must be spent with the locked input sequence
+	if utxo.LockTime > 0 {
+		input.Sequence = config.LockedInputSequence
+	}
+	return input
+}
+
 func (wallet *WalletImpl) removeLockedUTXOs(utxos []*UTXO) []*UTXO {
 	var availableUTXOs []*UTXO
 	var currentHeight = wallet.CurrentHeight(QueryHeightCode)
 	for _, utxo := range utxos {
-		if utxo.LockTime > 0 {
-			if utxo.LockTime >= currentHeight {
-				continue
-			}
-			utxo.LockTime = math.MaxUint32 - 1
+		if utxo.LockTime >= currentHeight {
+			continue
+		}
+		availableUTXOs = append(availableUTXOs, utxo)
+	}
+	return availableUTXOs
+}
+
+func removeFrozenUTXOs(utxos []*UTXO) []*UTXO {
+	var availableUTXOs []*UTXO
+	for _, utxo := range utxos {
+		if utxo.Frozen {
+			continue
 		}
 		availableUTXOs = append(availableUTXOs, utxo)
 	}